@@ -95,7 +95,7 @@ foreach ($item in $platforms ) {
     }
 
     if ($sign -eq "true") {
-        .\minisign -S -m $buildOutput
+        .\minisign -S -H -m $buildOutput
     }
 
     $build = [ordered]@{
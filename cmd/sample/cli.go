@@ -30,7 +30,7 @@ import (
 		#1: build this file (cli.go)
 
 			go build cli.go -ldflags "-X github.com/haevg-rz/go-updater/updater.UpdateFilesPubKey=
-			RWQWiK4rVAaJQhk42Y8obU0llCEwBcVWwzliy8T0bYj0WC+JZR8xdntY" -o {go-updater directory}
+			RWTLfCGTu+be8nHFHWa3tcqT04KRCnznkrbxIxa/qtWIfWMHSo1SSiPw" -o {go-updater directory}
 
 			use -ldflags to set the public Key matching to the private Key which was used to
 			encrypt the sample updates
@@ -45,18 +45,23 @@ import (
 		#8: the console should output the new content of HelloWorld.txt
 			which is 'Hello World And Hello Gophers!'
 		#9: done!
+
 		Results -->
 		#1: {workingDirectory}/cmd/sample/installed/HelloWorld/HelloWorld.txt content changed
 			from 'HelloWorld' to 'HelloWorld And Hello Gophers!'
 		#2: {workingDirectory}/cmd/sample/installed/HelloWorld/HelloWorld_version.json content
 			changed from '1.0.0' to '1.0.1'
 
+	>> Updating from an offline bundle <<
+		Zip the updates directory ({workingDirectory}/cmd/sample/updates) or its content into a bundle,
+		e.g. bundle.zip, and type '--import {path to bundle.zip}' into the console. HelloWorld is updated
+		from the bundle after the signatures were verified, as if the updates were read from the CDN.
 
 <- Build ->
 	=> in order to apply any updates, set the publicKey to the matching private key with which
 		the signatures were created.
 	-ldflags "-X github.com/haevg-rz/go-updater/updater.UpdateFilesPubKey=
-			RWQWiK4rVAaJQhk42Y8obU0llCEwBcVWwzliy8T0bYj0WC+JZR8xdntY"
+			RWTLfCGTu+be8nHFHWa3tcqT04KRCnznkrbxIxa/qtWIfWMHSo1SSiPw"
 
 	=> in order to apply self updates, build the project with ldflags
 	-ldflags "-X main.AppName=myCore -X main.Channel=Beta -X main.Platform=windows -X main.Architecture=amd64 -X main.Version=1.0.0"
@@ -167,7 +172,7 @@ func startReader() {
 }
 
 func readConsoleCommands() {
-	commands := []string{"--exit", "--help", "--version", "--check HelloWorld", "--check self", "--bg HelloWorld"}
+	commands := []string{"--exit", "--help", "--version", "--check HelloWorld", "--check self", "--bg HelloWorld", "--import {bundle.zip}"}
 	for {
 		command, _, err := reader.ReadLine()
 		if err != nil {
@@ -200,6 +205,12 @@ func readConsoleCommands() {
 			}
 		*/
 		default:
+			if bundleFile := strings.TrimPrefix(string(command), "--import "); bundleFile != string(command) {
+				printHW()
+				ImportBundle(bundleFile, helloWorld)
+				printHW()
+				break
+			}
 			fmt.Println("unrecognized command: ", string(command))
 		}
 	}
@@ -298,6 +309,25 @@ func CheckForSelfUpdates(asset updater.Asset) {
 	return
 }
 
+func ImportBundle(bundleFile string, assets ...updater.Asset) {
+	fmt.Println("importing updates from ", bundleFile, "...")
+	results, err := updater.ImportBundle(bundleFile, assets...)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, result := range results {
+		switch {
+		case result.Err != nil:
+			fmt.Println("could not update", result.AssetName, ":", result.Err)
+		case !result.Updated:
+			fmt.Println("no updates found for", result.AssetName)
+		default:
+			fmt.Println("successfully updated ", result.AssetName, " to ", result.UpdatedTo.Version)
+		}
+	}
+}
+
 func startProcess(asset updater.Asset) error {
 	command := fmt.Sprint("cmd /c Start ", asset.AssetName, ".exe")
 	parts := strings.Split(command, " ")
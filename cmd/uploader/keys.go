@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/haevg-rz/go-updater/internal/signing"
+	"github.com/haevg-rz/go-updater/updater"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	// passwordEnv holds the password of the secret key, e.g. in CI pipelines.
+	passwordEnv = "UPLOADER_KEY_PASSWORD"
+	// newPasswordEnv holds the password of the new secret key of keygen and rotate.
+	newPasswordEnv = "UPLOADER_NEW_KEY_PASSWORD"
+
+	keysDir = "keys"
+	// publicKeySuffix is the extension of public key files, named by their key id, e.g. keys/E3B0C44298FC1C14.pub
+	publicKeySuffix = ".pub"
+)
+
+// loadSecretKey reads and decrypts the minisign secret key file. The password is read from passwordFile, the
+// environment variable passwordEnv or prompted for, in this order.
+func loadSecretKey(keyFile string, passwordFile string, passwordEnv string) (key *signing.SecretKey, err error) {
+	encoded, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	password, err := getPassword(passwordFile, passwordEnv)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := signing.DecodeSecretKey(string(encoded), password)
+	if errors.Is(err, signing.ErrIncorrectPassword) && password == nil {
+		if password, err = promptPassword(keyFile); err != nil {
+			return nil, err
+		}
+		decoded, err = signing.DecodeSecretKey(string(encoded), password)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", keyFile, err)
+	}
+	return &decoded, nil
+}
+
+// getPassword returns nil if neither passwordFile nor the environment variable are set.
+func getPassword(passwordFile string, passwordEnv string) (password []byte, err error) {
+	if passwordFile != "" {
+		data, err := ioutil.ReadFile(passwordFile)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strings.TrimRight(string(data), "\r\n")), nil
+	}
+	if password, found := os.LookupEnv(passwordEnv); found {
+		return []byte(password), nil
+	}
+	return nil, nil
+}
+
+func promptPassword(keyFile string) (password []byte, err error) {
+	fmt.Fprintf(os.Stderr, "password for %s: ", keyFile)
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return nil, errors.New("no password entered")
+	}
+	return []byte(strings.TrimRight(line, "\r\n")), nil
+}
+
+// stdin is shared by all prompts, so buffered input is not lost between them.
+var stdin = bufio.NewReader(os.Stdin)
+
+func runKeygen(args []string) error {
+	flags := flag.NewFlagSet("keygen", flag.ContinueOnError)
+	secretKeyFile := flags.String("secret-key", "minisign.key", "file the encrypted secret key is written to")
+	publicKeyFile := flags.String("public-key", "minisign.pub", "file the public key is written to")
+	passwordFile := flags.String("password-file", "", "file containing the password of the new secret key, defaults to $"+newPasswordEnv+" or a prompt")
+	noPassword := flags.Bool("W", false, "store the secret key unencrypted")
+	force := flags.Bool("f", false, "overwrite existing key files")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	key, err := generateKey(*secretKeyFile, *publicKeyFile, *passwordFile, *noPassword, *force)
+	if err != nil {
+		return err
+	}
+	fmt.Println("key id:", updater.FormatKeyId(key.KeyId))
+	fmt.Println("public key:", key.PublicKey())
+	fmt.Printf("build clients with -ldflags \"-X github.com/haevg-rz/go-updater/updater.UpdateFilesPubKey=%s\"\n", key.PublicKey())
+	return nil
+}
+
+// generateKey creates a key pair and writes it to the secret and public key files.
+func generateKey(secretKeyFile string, publicKeyFile string, passwordFile string, noPassword bool, force bool) (key signing.SecretKey, err error) {
+	if !force {
+		for _, file := range []string{secretKeyFile, publicKeyFile} {
+			if _, err = os.Stat(file); err == nil {
+				return signing.SecretKey{}, fmt.Errorf("%s already exists, use -f to overwrite it", file)
+			}
+		}
+	}
+	var password []byte
+	if !noPassword {
+		if password, err = getNewPassword(secretKeyFile, passwordFile); err != nil {
+			return signing.SecretKey{}, err
+		}
+	}
+
+	if key, err = signing.GenerateSecretKey(); err != nil {
+		return signing.SecretKey{}, err
+	}
+	encoded, err := key.Encode(password)
+	if err != nil {
+		return signing.SecretKey{}, err
+	}
+	if err = ioutil.WriteFile(secretKeyFile, []byte(encoded), 0600); err != nil {
+		return signing.SecretKey{}, err
+	}
+	if err = ioutil.WriteFile(publicKeyFile, []byte(key.EncodePublicKey()), 0644); err != nil {
+		return signing.SecretKey{}, err
+	}
+	return key, nil
+}
+
+// getNewPassword reads the password of a new secret key from passwordFile, the environment variable
+// UPLOADER_NEW_KEY_PASSWORD or prompts for it twice.
+func getNewPassword(keyFile string, passwordFile string) (password []byte, err error) {
+	password, err = getPassword(passwordFile, newPasswordEnv)
+	if err != nil || password != nil {
+		return password, err
+	}
+	if password, err = promptPassword(keyFile); err != nil {
+		return nil, err
+	}
+	fmt.Fprint(os.Stderr, "repeat ")
+	repeated, err := promptPassword(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if string(password) != string(repeated) {
+		return nil, errors.New("passwords do not match")
+	}
+	if len(password) == 0 {
+		return nil, errors.New("empty password, use -W to store the secret key unencrypted")
+	}
+	return password, nil
+}
+
+func runRotate(args []string) error {
+	flags := flag.NewFlagSet("rotate", flag.ContinueOnError)
+	targetFlags := addTargetFlags(flags)
+	keyFile := flags.String("key", "", "current secret key, whose public key is built into the clients")
+	passwordFile := flags.String("password-file", "", "file containing the password of -key, defaults to $"+passwordEnv+" or a prompt")
+	newKeyFile := flags.String("new-key", "", "new secret key, created with keygen")
+	newPasswordFile := flags.String("new-password-file", "", "file containing the password of -new-key, defaults to $"+newPasswordEnv+" or a prompt")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *keyFile == "" || *newKeyFile == "" {
+		return errors.New("-key and -new-key are required")
+	}
+
+	target, err := targetFlags.newTarget()
+	if err != nil {
+		return err
+	}
+	key, err := loadSecretKey(*keyFile, *passwordFile, passwordEnv)
+	if err != nil {
+		return err
+	}
+	newKey, err := loadSecretKey(*newKeyFile, *newPasswordFile, newPasswordEnv)
+	if err != nil {
+		return err
+	}
+	if err = rotate(target, *key, *newKey); err != nil {
+		return err
+	}
+	fmt.Println("published key", updater.FormatKeyId(newKey.KeyId), "signed by", updater.FormatKeyId(key.KeyId))
+	return nil
+}
+
+// rotate publishes the public key of newKey as keys/{KeyId}.pub, signed by key, so clients with LearnRotatedKeys can
+// verify the new key with the key they already trust. The signature is written last, a key is only learned with it.
+func rotate(target Target, key signing.SecretKey, newKey signing.SecretKey) error {
+	if key.KeyId == newKey.KeyId {
+		return errors.New("the new key has the same key id as the current key")
+	}
+	newKeyId := updater.FormatKeyId(newKey.KeyId)
+	name := path.Join(keysDir, newKeyId+publicKeySuffix)
+	publicKey := newKey.EncodePublicKey()
+	if err := target.Put(name, strings.NewReader(publicKey)); err != nil {
+		return err
+	}
+	return putSignature(target, strings.NewReader(publicKey), key, updater.SignedFile{File: name})
+}
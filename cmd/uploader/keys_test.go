@@ -0,0 +1,68 @@
+package main
+
+import (
+	"github.com/Flaque/filet"
+	"github.com/haevg-rz/go-updater/internal/signing"
+	"github.com/haevg-rz/go-updater/updater"
+	"github.com/jedisct1/go-minisign"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/blake2b"
+	"path/filepath"
+	"testing"
+)
+
+func TestGenerateKey(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	dir := filet.TmpDir(t, "")
+	secretKeyFile, publicKeyFile := filepath.Join(dir, "minisign.key"), filepath.Join(dir, "minisign.pub")
+
+	//act
+	key, err := generateKey(secretKeyFile, publicKeyFile, "", true, false)
+	_, errExisting := generateKey(secretKeyFile, publicKeyFile, "", true, false)
+
+	//assert
+	assert.NoError(t, err)
+	assert.Error(t, errExisting)
+	loaded, err := loadSecretKey(secretKeyFile, "", passwordEnv)
+	if assert.NoError(t, err) {
+		assert.Equal(t, key, *loaded)
+	}
+	publicKey, err := minisign.NewPublicKeyFromFile(publicKeyFile)
+	if assert.NoError(t, err) {
+		assert.Equal(t, key.KeyId, publicKey.KeyId)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	target := dirTarget{Dir: filet.TmpDir(t, "")}
+	key, _ := signing.GenerateSecretKey()
+	newKey, _ := signing.GenerateSecretKey()
+
+	//act
+	err := rotate(target, key, newKey)
+
+	//assert
+	assert.NoError(t, err)
+	newKeyId := updater.FormatKeyId(newKey.KeyId)
+	publicKey := readTestFile(t, target, "keys/"+newKeyId+".pub")
+	assert.Equal(t, newKey.EncodePublicKey(), publicKey)
+	oldPublicKey, _ := minisign.NewPublicKey(key.PublicKey())
+	signature, err := minisign.DecodeSignature(readTestFile(t, target, "keys/"+newKeyId+".pub.minisig"))
+	if assert.NoError(t, err) {
+		// prehashed signatures are verified as legacy signatures of the BLAKE2b-512 hash
+		hash := blake2b.Sum512([]byte(publicKey))
+		signature.SignatureAlgorithm = [2]byte{'E', 'd'}
+		valid, err := oldPublicKey.Verify(hash[:], signature)
+		assert.NoError(t, err)
+		assert.True(t, valid)
+	}
+}
+
+func TestRotateSameKey(t *testing.T) {
+	defer filet.CleanUp(t)
+	key, _ := signing.GenerateSecretKey()
+	assert.Error(t, rotate(dirTarget{Dir: filet.TmpDir(t, "")}, key, key))
+}
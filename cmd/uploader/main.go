@@ -1,5 +1,84 @@
 package main
 
-func main(){
-  println("Hello World")
+import (
+	"fmt"
+	"os"
+)
+
+/*
+<- Information about this file ->
+	the uploader publishes releases into the file structure read by the updater package:
+
+	{AssetName}/{Channel}/latest.txt                             pointing to the latest major, eg. "1"
+	{AssetName}/{Channel}/{Major}/latest.txt                     pointing to the latest minor or patch, eg. "1.2.3"
+	{AssetName}/{Channel}/{Major}/{Version}.json                 describing the files of the version
+	{AssetName}/{Channel}/{Major}/{AssetName}_{Version}_{Specs}{FileExtension} the actual file
+
+<- Usage ->
+	uploader publish -target ./updates -asset MyApp -channel beta -version 1.2.3 \
+		-spec Platform=windows -spec Architecture=amd64 -file ./build/MyApp.exe -key ~/.minisign/minisign.key
+
+	uploader keygen -secret-key minisign.key -public-key minisign.pub
+
+	uploader rotate -target ./updates -key minisign.key -new-key new.key
+		publishes keys/{KeyId}.pub signed by the current key in keys/{KeyId}.pub.minisig, clients with
+		LearnRotatedKeys trust it once they verify a signature of the new key
+
+	uploader root -target ./updates -key root.key -role root=root.pub -role targets=targets.pub \
+		-role snapshot=snapshot.pub -role timestamp=timestamp.pub
+		publishes the next version of the repository root metadata as tuf/{Version}.root.json, a new version has to
+		be signed by the threshold of the current and of the new root keys
+
+	uploader publish ... -role-key targets=targets.key -role-key snapshot=snapshot.key -role-key timestamp=timestamp.key
+		adds the files of the release to tuf/{Version}.targets.json and publishes new snapshot and timestamp metadata
+
+	uploader refresh -target ./updates -role-key targets=targets.key -role-key snapshot=snapshot.key -role-key timestamp=timestamp.key
+		signs the repository metadata again before it expires
+
+	uploader resign -target ./updates -asset MyApp -channel beta -key minisign.key -expires 720h
+		signs the latest.txt files and version jsons of the channel again, so metadata published with -expires does
+		not expire while no release is published, after verifying their current signatures
+*/
+
+const usage = `usage: uploader <command> [flags]
+
+commands:
+  publish   publish a file of a release to the updates source
+  keygen    create a minisign key pair to sign releases with
+  rotate    publish a new public key signed by the current key
+  root      publish a new version of the repository root metadata
+  refresh   sign the targets, snapshot and timestamp metadata again before they expire
+  resign    sign the latest.txt files and version jsons of a channel again with a new expiry
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	switch os.Args[1] {
+	case "publish":
+		err = runPublish(os.Args[2:])
+	case "keygen":
+		err = runKeygen(os.Args[2:])
+	case "rotate":
+		err = runRotate(os.Args[2:])
+	case "root":
+		err = runRoot(os.Args[2:])
+	case "refresh":
+		err = runRefresh(os.Args[2:])
+	case "resign":
+		err = runResign(os.Args[2:])
+	case "-h", "--help", "help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/haevg-rz/go-updater/internal/signing"
+	"github.com/haevg-rz/go-updater/updater"
+	"github.com/jedisct1/go-minisign"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// legacySignatureAlgorithm identifies minisign signatures of the file itself instead of its BLAKE2b-512 hash.
+var legacySignatureAlgorithm = [2]byte{'E', 'd'}
+
+const (
+	latestFileName    = "latest.txt"
+	signatureSuffix   = ".minisig"
+	versionJsonIndent = "  "
+)
+
+type release struct {
+	Asset   string
+	Channel string
+	Version updater.Version
+	Specs   map[string]string
+	File    string
+	// MetadataExpiry is the validity of the signatures of the version json and the latest.txt files, 0 if they never expire.
+	MetadataExpiry time.Duration
+	// TrustedKeys are the base64 encoded public keys of previous signing keys. Published metadata is signed again only
+	// if it is signed by the signing key or one of them.
+	TrustedKeys []string
+	// AllowLegacySignature publishes a legacy minisign signature next to File, which clients have to verify in memory.
+	AllowLegacySignature bool
+}
+
+// specsFlag collects repeated -spec key=value flags.
+type specsFlag map[string]string
+
+func (s specsFlag) String() string {
+	pairs := make([]string, 0, len(s))
+	for key, value := range s {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (s specsFlag) Set(pair string) error {
+	parts := strings.SplitN(pair, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("spec %q is not of the form key=value", pair)
+	}
+	s[parts[0]] = parts[1]
+	return nil
+}
+
+func runPublish(args []string) error {
+	flags := flag.NewFlagSet("publish", flag.ContinueOnError)
+	targetFlags := addTargetFlags(flags)
+	asset := flags.String("asset", "", "name of the asset, e.g. MyApp")
+	channel := flags.String("channel", "", "channel of the release, e.g. beta")
+	version := flags.String("version", "", "semantic version of the release, e.g. 1.2.3")
+	file := flags.String("file", "", "file to publish, a .minisig next to it is published as well if -key is not set")
+	allowLegacySignature := flags.Bool("allow-legacy-signature", false, "publish a legacy .minisig next to -file, clients read the whole file into memory to verify it; sign with -key or minisign -S -H instead")
+	keyFile := flags.String("key", "", "minisign secret key to sign the file and the version json with")
+	expires := flags.Duration("expires", 0, "validity of the metadata signatures, e.g. 720h, clients reject expired metadata, sign them again with uploader resign; 0 never expires")
+	passwordFile := flags.String("password-file", "", "file containing the password of the secret keys, defaults to $"+passwordEnv+" or a prompt")
+	trustedKeyFiles := &filesFlag{}
+	flags.Var(trustedKeyFiles, "trusted-key", "public key file of a previous signing key, e.g. before uploader rotate, metadata signed by it is signed again by -key (repeatable)")
+	roleKeyFiles := roleFilesFlag{}
+	flags.Var(roleKeyFiles, "role-key", "secret key of the targets, snapshot or timestamp role as role=file, publishes the repository metadata in tuf/ (repeatable)")
+	repositoryExpires := flags.Duration("repository-expires", defaultRepositoryExpiry, "validity of the targets, snapshot and timestamp metadata")
+	specs := specsFlag{}
+	flags.Var(specs, "spec", "spec of the file as key=value, e.g. Platform=windows (repeatable)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	r, err := newRelease(*asset, *channel, *version, specs, *file)
+	if err != nil {
+		return err
+	}
+	r.MetadataExpiry = *expires
+	r.AllowLegacySignature = *allowLegacySignature
+	if r.TrustedKeys, err = readTrustedKeys(*trustedKeyFiles); err != nil {
+		return err
+	}
+	target, err := targetFlags.newTarget()
+	if err != nil {
+		return err
+	}
+	var key *signing.SecretKey
+	if *keyFile != "" {
+		if key, err = loadSecretKey(*keyFile, *passwordFile, passwordEnv); err != nil {
+			return err
+		}
+	}
+	roleKeys, err := roleKeyFiles.loadKeys(*passwordFile)
+	if err != nil {
+		return err
+	}
+	var addTargets func(files map[string]updater.TargetFile) error
+	if len(roleKeys) > 0 {
+		if err = checkRepository(target, roleKeys); err != nil {
+			return err
+		}
+		addTargets = func(files map[string]updater.TargetFile) error {
+			return putRepository(target, roleKeys, *repositoryExpires, files)
+		}
+	}
+	filePath, err := publish(target, r, key, addTargets)
+	if err != nil {
+		return err
+	}
+	fmt.Println("published", filePath)
+	return nil
+}
+
+func newRelease(asset string, channel string, version string, specs map[string]string, file string) (r release, err error) {
+	for name, value := range map[string]string{"-asset": asset, "-channel": channel, "-version": version, "-file": file} {
+		if value == "" {
+			return release{}, fmt.Errorf("%s is required", name)
+		}
+	}
+	for _, name := range []string{asset, channel} {
+		if strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+			return release{}, fmt.Errorf("invalid name %q", name)
+		}
+	}
+	v, err := updater.ParseVersion(version)
+	if err != nil {
+		return release{}, err
+	}
+	return release{Asset: asset, Channel: channel, Version: v, Specs: specs, File: file}, nil
+}
+
+// publish uploads the file and its signature before the metadata pointing to it, so clients never see a version
+// whose files are missing. With a key, the file, the version json and the latest.txt files are signed, otherwise an
+// existing signature next to the file is published. addTargets is called with the length and hashes of the files of
+// the release before the latest.txt files point to it, so they are listed in the repository metadata before clients
+// read them; nil without repository metadata. Returns the path of the file in the updates source.
+func publish(target Target, r release, key *signing.SecretKey, addTargets func(files map[string]updater.TargetFile) error) (filePath string, err error) {
+	majorDir := r.getMajorDir()
+	filePath = path.Join(majorDir, r.getFileName())
+
+	if key == nil && !r.AllowLegacySignature {
+		if err = checkSignatureAlgorithm(r.File + signatureSuffix); err != nil {
+			return "", err
+		}
+	}
+	if err = putFile(target, filePath, r.File); err != nil {
+		return "", err
+	}
+	if key != nil {
+		err = putFileSignature(target, r.File, *key, r.getSignedFile(filePath))
+	} else if err = putFile(target, filePath+signatureSuffix, r.File+signatureSuffix); errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var trusted *updater.KeyRing
+	if key != nil {
+		if trusted, err = newTrustedKeyRing(*key, r.TrustedKeys); err != nil {
+			return "", err
+		}
+	}
+	versionJson, err := putVersionJson(target, r, filePath, key, trusted)
+	if err != nil {
+		return "", err
+	}
+
+	newerVersion := func(current string) (bool, error) {
+		currentVersion, err := updater.ParseVersion(current)
+		if err != nil {
+			return false, err
+		}
+		return currentVersion.LessThan(r.Version), nil
+	}
+	majorLatest, err := getLatestIfNewer(target, r.getSignedMetadata(r.getMajorLatest()), r.Version.String(), newerVersion, trusted)
+	if err != nil {
+		return "", err
+	}
+
+	major := strconv.FormatUint(r.Version.Major, 10)
+	newerMajor := func(current string) (bool, error) {
+		currentMajor, err := strconv.ParseUint(current, 10, 64)
+		if err != nil {
+			return false, fmt.Errorf("invalid major %q", current)
+		}
+		return currentMajor < r.Version.Major, nil
+	}
+	channelSigned := r.getSignedMetadata(r.getChannelLatest())
+	channelSigned.Version = ""
+	channelLatest, err := getLatestIfNewer(target, channelSigned, major, newerMajor, trusted)
+	if err != nil {
+		return "", err
+	}
+
+	if addTargets != nil {
+		files, err := getTargetFiles(r, filePath, map[string][]byte{
+			r.getVersionJson():   versionJson,
+			r.getMajorLatest():   majorLatest.Content,
+			r.getChannelLatest(): channelLatest.Content,
+		})
+		if err != nil {
+			return "", err
+		}
+		if err = addTargets(files); err != nil {
+			return "", err
+		}
+	}
+	if err = majorLatest.put(target, key); err != nil {
+		return "", err
+	}
+	if err = channelLatest.put(target, key); err != nil {
+		return "", err
+	}
+	return filePath, nil
+}
+
+// getMajorDir example: MyApp/beta/1
+func (r release) getMajorDir() string {
+	return path.Join(r.Asset, r.Channel, strconv.FormatUint(r.Version.Major, 10))
+}
+
+// getVersionJson example: MyApp/beta/1/1.2.3.json
+func (r release) getVersionJson() string {
+	return path.Join(r.getMajorDir(), r.Version.String()+".json")
+}
+
+// getMajorLatest example: MyApp/beta/1/latest.txt
+func (r release) getMajorLatest() string {
+	return path.Join(r.getMajorDir(), latestFileName)
+}
+
+// getChannelLatest example: MyApp/beta/latest.txt
+func (r release) getChannelLatest() string {
+	return path.Join(r.Asset, r.Channel, latestFileName)
+}
+
+// getSignedFile returns the fields of the trusted comment binding the signature of name to the release.
+func (r release) getSignedFile(name string) updater.SignedFile {
+	return updater.SignedFile{File: name, Asset: r.Asset, Channel: r.Channel, Version: r.Version.String()}
+}
+
+// getSignedMetadata is like getSignedFile, the signature expires after MetadataExpiry.
+func (r release) getSignedMetadata(name string) updater.SignedFile {
+	signed := r.getSignedFile(name)
+	if r.MetadataExpiry > 0 {
+		signed.Expires = time.Now().Add(r.MetadataExpiry).Unix()
+	}
+	return signed
+}
+
+// getFileName example: MyApp_1.2.3_amd64_windows.exe with the spec values sorted by their keys
+func (r release) getFileName() string {
+	parts := []string{r.Asset, r.Version.String()}
+	keys := make([]string, 0, len(r.Specs))
+	for key := range r.Specs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		parts = append(parts, r.Specs[key])
+	}
+	return strings.Join(parts, "_") + filepath.Ext(r.File)
+}
+
+func putFile(target Target, name string, file string) error {
+	source, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	return target.Put(name, source)
+}
+
+func putFileSignature(target Target, file string, key signing.SecretKey, signed updater.SignedFile) error {
+	source, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	return putSignature(target, source, key, signed)
+}
+
+// putSignature signs content with a trusted comment binding it to signed and writes the signature next to signed.File.
+// Signatures are put after the content they sign, so a failed upload never leaves a new signature next to old content.
+func putSignature(target Target, content io.Reader, key signing.SecretKey, signed updater.SignedFile) error {
+	signed.Timestamp = time.Now().Unix()
+	signature, err := key.Sign(content, signed.TrustedComment())
+	if err != nil {
+		return err
+	}
+	return target.Put(signed.File+signatureSuffix, strings.NewReader(signature))
+}
+
+// putVersionJson adds the file with its size and hashes to the {Version}.json of the release, replacing an entry with
+// the same specs, and returns its content. With a key, the version json is signed as well, the entries of an existing
+// version json are only kept if its signature is trusted.
+func putVersionJson(target Target, r release, filePath string, key *signing.SecretKey, trusted *updater.KeyRing) (data []byte, err error) {
+	name := r.getVersionJson()
+	var updates []updater.AvailableUpdate
+	data, err = target.Get(name)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		if trusted != nil {
+			if err = verifySignature(target, data, r.getSignedFile(name), trusted); err != nil {
+				return nil, err
+			}
+		}
+		if err = json.Unmarshal(data, &updates); err != nil {
+			return nil, fmt.Errorf("invalid version json %s: %v", name, err)
+		}
+	}
+
+	file, err := getTargetFile(r.File)
+	if err != nil {
+		return nil, err
+	}
+	update := updater.AvailableUpdate{
+		Asset:    r.Asset,
+		Channel:  r.Channel,
+		Version:  r.Version.String(),
+		Specs:    r.Specs,
+		FilePath: filePath,
+		Size:     file.Length,
+		Sha256:   file.Hashes["sha256"],
+		Sha512:   file.Hashes["sha512"],
+	}
+	replaced := false
+	for i := range updates {
+		if isSameSpecs(updates[i].Specs, r.Specs) {
+			updates[i], replaced = update, true
+		}
+	}
+	if !replaced {
+		updates = append(updates, update)
+	}
+
+	data, err = json.MarshalIndent(updates, "", versionJsonIndent)
+	if err != nil {
+		return nil, err
+	}
+	if err = target.Put(name, bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	if key != nil {
+		return data, putSignature(target, bytes.NewReader(data), *key, r.getSignedMetadata(name))
+	}
+	return data, nil
+}
+
+// latestFile is the content of a latest.txt after publishing a release, Changed if it has to be written.
+type latestFile struct {
+	Signed  updater.SignedFile
+	Content []byte
+	Changed bool
+}
+
+// getLatestIfNewer returns the latest.txt signed.File pointing to value, unless it already points to an equal or newer
+// value. A latest.txt which is kept has to be signed by a trusted key, unless trusted is nil.
+func getLatestIfNewer(target Target, signed updater.SignedFile, value string, isNewer func(current string) (bool, error), trusted *updater.KeyRing) (latestFile, error) {
+	current, err := target.Get(signed.File)
+	if errors.Is(err, os.ErrNotExist) {
+		return latestFile{Signed: signed, Content: []byte(value), Changed: true}, nil
+	}
+	if err != nil {
+		return latestFile{}, err
+	}
+	currentValue := strings.TrimSpace(string(current))
+	newer, err := isNewer(currentValue)
+	if err != nil {
+		return latestFile{}, fmt.Errorf("%s: %v", signed.File, err)
+	}
+	if newer {
+		return latestFile{Signed: signed, Content: []byte(value), Changed: true}, nil
+	}
+	if signed.Version != "" {
+		signed.Version = currentValue
+	}
+	if trusted != nil {
+		if err = verifySignature(target, current, signed, trusted); err != nil {
+			return latestFile{}, err
+		}
+	}
+	return latestFile{Signed: signed, Content: current}, nil
+}
+
+// put writes the latest.txt if it changed. With a key, the latest.txt is signed as well. An unchanged latest.txt is
+// signed again, so its signature is newer than the metadata it points to and does not expire before it.
+func (l latestFile) put(target Target, key *signing.SecretKey) error {
+	if l.Changed {
+		if err := target.Put(l.Signed.File, bytes.NewReader(l.Content)); err != nil {
+			return err
+		}
+	}
+	if key == nil {
+		return nil
+	}
+	return putSignature(target, bytes.NewReader(l.Content), *key, l.Signed)
+}
+
+// checkSignatureAlgorithm rejects a legacy minisign signature, signing the file itself instead of its hash. Clients have
+// to read the whole file into memory to verify it. A missing signature is not checked.
+func checkSignatureAlgorithm(signatureFile string) error {
+	data, err := ioutil.ReadFile(signatureFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	signature, err := minisign.DecodeSignature(string(data))
+	if err != nil {
+		return fmt.Errorf("%s: %v", signatureFile, err)
+	}
+	if signature.SignatureAlgorithm == legacySignatureAlgorithm {
+		return fmt.Errorf("%s is a legacy signature, clients read the whole file into memory to verify it; sign with -key or minisign -S -H, or set -allow-legacy-signature", signatureFile)
+	}
+	return nil
+}
+
+// readTrustedKeys reads the public key files of previous signing keys.
+func readTrustedKeys(files []string) (publicKeys []string, err error) {
+	for _, file := range files {
+		_, publicKey, err := readPublicKey(file)
+		if err != nil {
+			return nil, err
+		}
+		publicKeys = append(publicKeys, publicKey)
+	}
+	return publicKeys, nil
+}
+
+// newTrustedKeyRing trusts key and the public keys of previous signing keys.
+func newTrustedKeyRing(key signing.SecretKey, publicKeys []string) (*updater.KeyRing, error) {
+	return updater.NewKeyRing(append([]string{key.PublicKey()}, publicKeys...)...)
+}
+
+// verifySignature verifies the signature next to expected.File of content read from the updates source before it is
+// signed again, so content changed in the updates source never gets a new signature. The trusted comment has to match
+// the file, asset, channel and version of expected.
+func verifySignature(target Target, content []byte, expected updater.SignedFile, trusted *updater.KeyRing) error {
+	signature, err := target.Get(expected.File + signatureSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%s: %w, it is not signed again", expected.File, updater.ErrSignatureMissing)
+	}
+	if err != nil {
+		return err
+	}
+	signed, err := trusted.Verify(bytes.NewReader(content), string(signature))
+	if err != nil {
+		return fmt.Errorf("%s: %w, it is not signed again", expected.File, err)
+	}
+	for _, field := range [][3]string{
+		{"file", expected.File, signed.File},
+		{"asset", expected.Asset, signed.Asset},
+		{"channel", expected.Channel, signed.Channel},
+		{"version", expected.Version, signed.Version},
+	} {
+		if field[1] != field[2] {
+			return fmt.Errorf("%w: %s is signed for %s %q instead of %q, it is not signed again", updater.ErrMetadataMismatch, expected.File, field[0], field[2], field[1])
+		}
+	}
+	return nil
+}
+
+func isSameSpecs(a map[string]string, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if other, found := b[key]; !found || other != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/Flaque/filet"
+	"github.com/haevg-rz/go-updater/updater"
+	"github.com/jedisct1/go-minisign"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func publishTestFile(t *testing.T, target dirTarget, version string, specs map[string]string) {
+	file := filet.TmpFile(t, "", "payload "+version).Name()
+	r, err := newRelease("MyApp", "beta", version, specs, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = publish(target, r, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readTestFile(t *testing.T, target dirTarget, name string) string {
+	data, err := target.Get(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestPublish(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	target := dirTarget{Dir: filet.TmpDir(t, "")}
+
+	//act
+	publishTestFile(t, target, "1.2.0", map[string]string{"Platform": "windows", "Architecture": "amd64"})
+	publishTestFile(t, target, "1.2.0", map[string]string{"Platform": "linux", "Architecture": "amd64"})
+	publishTestFile(t, target, "1.2.0", map[string]string{"Platform": "linux", "Architecture": "amd64"})
+	publishTestFile(t, target, "1.1.9", map[string]string{"Platform": "linux", "Architecture": "amd64"})
+
+	//assert
+	assert.Equal(t, "1", readTestFile(t, target, "MyApp/beta/latest.txt"))
+	assert.Equal(t, "1.2.0", readTestFile(t, target, "MyApp/beta/1/latest.txt"))
+	assert.Equal(t, "payload 1.2.0", readTestFile(t, target, "MyApp/beta/1/MyApp_1.2.0_amd64_windows"))
+	var updates []updater.AvailableUpdate
+	assert.NoError(t, json.Unmarshal([]byte(readTestFile(t, target, "MyApp/beta/1/1.2.0.json")), &updates))
+	file, _ := updater.NewTargetFile(strings.NewReader("payload 1.2.0"))
+	assert.Equal(t, []updater.AvailableUpdate{
+		{Asset: "MyApp", Channel: "beta", Version: "1.2.0", Specs: map[string]string{"Platform": "windows", "Architecture": "amd64"}, FilePath: "MyApp/beta/1/MyApp_1.2.0_amd64_windows", Size: 13, Sha256: file.Hashes["sha256"], Sha512: file.Hashes["sha512"]},
+		{Asset: "MyApp", Channel: "beta", Version: "1.2.0", Specs: map[string]string{"Platform": "linux", "Architecture": "amd64"}, FilePath: "MyApp/beta/1/MyApp_1.2.0_amd64_linux", Size: 13, Sha256: file.Hashes["sha256"], Sha512: file.Hashes["sha512"]},
+	}, updates)
+}
+
+func TestPublishNewMajor(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	target := dirTarget{Dir: filet.TmpDir(t, "")}
+
+	//act
+	publishTestFile(t, target, "9.0.0", nil)
+	publishTestFile(t, target, "10.0.0", nil)
+	publishTestFile(t, target, "9.0.1", nil)
+
+	//assert
+	assert.Equal(t, "10", readTestFile(t, target, "MyApp/beta/latest.txt"))
+	assert.Equal(t, "9.0.1", readTestFile(t, target, "MyApp/beta/9/latest.txt"))
+	assert.Equal(t, "10.0.0", readTestFile(t, target, "MyApp/beta/10/latest.txt"))
+}
+
+func TestPublishIsFoundByUpdater(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	target := dirTarget{Dir: filet.TmpDir(t, "")}
+	specs := map[string]string{"Platform": "linux"}
+	publishTestFile(t, target, "1.0.1", specs)
+	asset := updater.Asset{AssetName: "MyApp", AssetVersion: "1.0.0", Channel: "beta", Specs: specs, Client: updater.LocalClient{CdnBaseUrl: target.Dir}}
+
+	//act
+	updates, updateFound, err := asset.CheckForUpdates()
+
+	//assert
+	assert.NoError(t, err)
+	assert.True(t, updateFound)
+	if assert.Len(t, updates, 1) {
+		assert.Equal(t, "1.0.1", updates[0].Version)
+		assert.Equal(t, "MyApp/beta/1/MyApp_1.0.1_linux", filepath.ToSlash(updates[0].Path))
+	}
+}
+
+// writeTestSecretKey writes an unencrypted key pair and returns the secret key file and the public key.
+func writeTestSecretKey(t *testing.T) (keyFile string, publicKey string) {
+	dir := filet.TmpDir(t, "")
+	keyFile = filepath.Join(dir, "minisign.key")
+	key, err := generateKey(keyFile, filepath.Join(dir, "minisign.pub"), "", true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyFile, key.PublicKey()
+}
+
+func TestPublishSigned(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	target := dirTarget{Dir: filet.TmpDir(t, "")}
+	keyFile, publicKey := writeTestSecretKey(t)
+	key, err := loadSecretKey(keyFile, "", passwordEnv)
+	assert.NoError(t, err)
+	defer func(pubKey string) { updater.UpdateFilesPubKey = pubKey }(updater.UpdateFilesPubKey)
+	updater.UpdateFilesPubKey = publicKey
+	file := filepath.Join(filet.TmpDir(t, ""), "HelloWorld.txt")
+	_ = ioutil.WriteFile(file, []byte("Hello Gophers"), 0644)
+	r, _ := newRelease("HelloWorld", "beta", "1.0.1", nil, file)
+	asset := updater.Asset{AssetName: "HelloWorld", AssetVersion: "1.0.0", Channel: "beta", Specs: map[string]string{}, Client: updater.LocalClient{CdnBaseUrl: target.Dir}, TargetFolder: filet.TmpDir(t, ""), RequireSignedMetadata: true}
+	_ = ioutil.WriteFile(filepath.Join(asset.TargetFolder, "HelloWorld.txt"), []byte("Hello World"), 0644)
+
+	//act
+	_, err = publish(target, r, key, nil)
+
+	//assert
+	assert.NoError(t, err)
+	_, updated, err := asset.Update()
+	assert.NoError(t, err)
+	assert.True(t, updated)
+	got, _ := ioutil.ReadFile(filepath.Join(asset.TargetFolder, "HelloWorld.txt"))
+	assert.Equal(t, "Hello Gophers", string(got))
+	signature, err := minisign.DecodeSignature(readTestFile(t, target, "HelloWorld/beta/1/1.0.1.json.minisig"))
+	assert.NoError(t, err)
+	assert.Contains(t, signature.TrustedComment, "\tfile:HelloWorld/beta/1/1.0.1.json\tasset:HelloWorld\tchannel:beta\tversion:1.0.1")
+}
+
+func Test_newRelease(t *testing.T) {
+	tests := []struct {
+		name    string
+		asset   string
+		version string
+		file    string
+		wantErr bool
+	}{
+		{"valid", "MyApp", "v1.2.3-rc.1", "MyApp.exe", false},
+		{"missing file", "MyApp", "1.2.3", "", true},
+		{"invalid version", "MyApp", "1.2", "MyApp.exe", true},
+		{"path in asset name", "../MyApp", "1.2.3", "MyApp.exe", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newRelease(tt.asset, "beta", tt.version, nil, tt.file)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("newRelease() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPublishMetadataExpiry(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	target := dirTarget{Dir: filet.TmpDir(t, "")}
+	keyFile, _ := writeTestSecretKey(t)
+	key, err := loadSecretKey(keyFile, "", passwordEnv)
+	assert.NoError(t, err)
+	r, _ := newRelease("HelloWorld", "beta", "1.0.1", nil, filet.TmpFile(t, "", "Hello Gophers").Name())
+	r.MetadataExpiry = time.Hour
+
+	//act
+	_, err = publish(target, r, key, nil)
+
+	//assert
+	assert.NoError(t, err)
+	for name, wantExpires := range map[string]bool{
+		"HelloWorld/beta/latest.txt.minisig":         true,
+		"HelloWorld/beta/1/latest.txt.minisig":       true,
+		"HelloWorld/beta/1/1.0.1.json.minisig":       true,
+		"HelloWorld/beta/1/HelloWorld_1.0.1.minisig": false,
+	} {
+		signature, err := minisign.DecodeSignature(readTestFile(t, target, name))
+		assert.NoError(t, err)
+		signed, err := updater.ParseTrustedComment(signature.TrustedComment)
+		assert.NoError(t, err)
+		if wantExpires {
+			assert.InDelta(t, time.Now().Add(time.Hour).Unix(), signed.Expires, 60, name)
+		} else {
+			assert.Zero(t, signed.Expires, name)
+		}
+	}
+}
+
+func TestPublishSignedVerifiesExistingMetadata(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	keyFile, _ := writeTestSecretKey(t)
+	key, err := loadSecretKey(keyFile, "", passwordEnv)
+	assert.NoError(t, err)
+	newTarget := func() dirTarget {
+		target := dirTarget{Dir: filet.TmpDir(t, "")}
+		r, _ := newRelease("MyApp", "beta", "1.0.1", map[string]string{"Platform": "linux"}, filet.TmpFile(t, "", "payload").Name())
+		if _, err = publish(target, r, key, nil); err != nil {
+			t.Fatal(err)
+		}
+		return target
+	}
+	tamperedVersionJson := newTarget()
+	_ = tamperedVersionJson.Put("MyApp/beta/1/1.0.1.json", strings.NewReader(`[{"asset":"MyApp","channel":"beta","version":"1.0.1","specs":{"Platform":"linux"},"filePath":"MyApp/beta/1/evil"}]`))
+	tamperedLatest := newTarget()
+	_ = tamperedLatest.Put("MyApp/beta/1/latest.txt", strings.NewReader("1.0.3"))
+	tests := []struct {
+		name      string
+		target    dirTarget
+		version   string
+		wantErrIs error
+	}{
+		{"entries of a tampered version json are not merged", tamperedVersionJson, "1.0.1", updater.ErrSignatureInvalid},
+		{"tampered latest.txt is not signed again", tamperedLatest, "1.0.2", updater.ErrSignatureInvalid},
+		{"signed metadata", newTarget(), "1.0.1", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := newRelease("MyApp", "beta", tt.version, map[string]string{"Platform": "windows"}, filet.TmpFile(t, "", "payload").Name())
+
+			//act
+			_, err := publish(tt.target, r, key, nil)
+
+			//assert
+			if tt.wantErrIs == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.True(t, errors.Is(err, tt.wantErrIs), err)
+		})
+	}
+}
+
+func TestPublishLegacySignature(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	legacySignature := "untrusted comment: signature from minisign secret key\n" +
+		"RWQWiK4rVAaJQhVEBbbij2PvL4AYz4bNcpt8bNVemTFWw9zk52gbeYuJFvVlCgmoAZO6tg/RFvkDfToCsI5xUABAsDfoXXCRYQM=\n" +
+		"trusted comment: timestamp:1616406169\tfile:HelloWorld_1.0.1.txt\n" +
+		"u7RXMpI20tU2R/p7rFY9IQBo/bt2gTzo2SPOWDs13wHeqxDS1Yso/cOfDqjHMXBLpybAcr30RXaWOJbESkunCw==\n"
+	file := filet.TmpFile(t, "", "Hello Gophers").Name()
+	_ = ioutil.WriteFile(file+signatureSuffix, []byte(legacySignature), 0644)
+	r, _ := newRelease("HelloWorld", "beta", "1.0.1", nil, file)
+	rejected := dirTarget{Dir: filet.TmpDir(t, "")}
+	allowed := dirTarget{Dir: filet.TmpDir(t, "")}
+
+	//act
+	_, rejectedErr := publish(rejected, r, nil, nil)
+	r.AllowLegacySignature = true
+	_, allowedErr := publish(allowed, r, nil, nil)
+
+	//assert
+	assert.Error(t, rejectedErr)
+	_, err := rejected.Get("HelloWorld/beta/1/HelloWorld_1.0.1" + filepath.Ext(file))
+	assert.True(t, errors.Is(err, os.ErrNotExist), err)
+	assert.NoError(t, allowedErr)
+	assert.Equal(t, legacySignature, readTestFile(t, allowed, "HelloWorld/beta/1/HelloWorld_1.0.1.minisig"))
+}
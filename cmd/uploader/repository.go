@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/haevg-rz/go-updater/internal/signing"
+	"github.com/haevg-rz/go-updater/updater"
+	"github.com/jedisct1/go-minisign"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRootExpiry       = 365 * 24 * time.Hour
+	defaultRepositoryExpiry = 30 * 24 * time.Hour
+)
+
+// repositoryRoles are the roles of a root metadata, every role needs at least one key.
+var repositoryRoles = []string{updater.RoleRoot, updater.RoleTargets, updater.RoleSnapshot, updater.RoleTimestamp}
+
+// roleFilesFlag collects repeated -role-key role=file flags.
+type roleFilesFlag map[string][]string
+
+func (r roleFilesFlag) String() string {
+	pairs := make([]string, 0, len(r))
+	for role, files := range r {
+		for _, file := range files {
+			pairs = append(pairs, role+"="+file)
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (r roleFilesFlag) Set(pair string) error {
+	parts := strings.SplitN(pair, "=", 2)
+	if len(parts) != 2 || parts[1] == "" || !isRepositoryRole(parts[0]) {
+		return fmt.Errorf("%q is not of the form role=file with role %s", pair, strings.Join(repositoryRoles, ", "))
+	}
+	r[parts[0]] = append(r[parts[0]], parts[1])
+	return nil
+}
+
+// filesFlag collects repeated file flags.
+type filesFlag []string
+
+func (f *filesFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *filesFlag) Set(file string) error {
+	*f = append(*f, file)
+	return nil
+}
+
+// loadKeys loads the secret keys of every role, a key file used for several roles is decrypted once.
+func (r roleFilesFlag) loadKeys(passwordFile string) (keys map[string][]signing.SecretKey, err error) {
+	keys = map[string][]signing.SecretKey{}
+	loaded := map[string]*signing.SecretKey{}
+	for role, files := range r {
+		for _, file := range files {
+			if loaded[file] == nil {
+				if loaded[file], err = loadSecretKey(file, passwordFile, passwordEnv); err != nil {
+					return nil, err
+				}
+			}
+			keys[role] = append(keys[role], *loaded[file])
+		}
+	}
+	return keys, nil
+}
+
+func isRepositoryRole(role string) bool {
+	for _, r := range repositoryRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func runRoot(args []string) error {
+	flags := flag.NewFlagSet("root", flag.ContinueOnError)
+	targetFlags := addTargetFlags(flags)
+	publicKeys := roleFilesFlag{}
+	flags.Var(publicKeys, "role", "public key file of a role as role=file, e.g. targets=targets.pub (repeatable)")
+	thresholds := specsFlag{}
+	flags.Var(thresholds, "threshold", "number of keys which have to sign the metadata of a role as role=n, defaults to 1 (repeatable)")
+	keyFiles := &filesFlag{}
+	flags.Var(keyFiles, "key", "secret key signing the root metadata, the threshold of the current and of the new root keys is needed (repeatable)")
+	passwordFile := flags.String("password-file", "", "file containing the password of the secret keys, defaults to $"+passwordEnv+" or a prompt")
+	expires := flags.Duration("expires", defaultRootExpiry, "validity of the root metadata")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	target, err := targetFlags.newTarget()
+	if err != nil {
+		return err
+	}
+	root, err := newRootMetadata(publicKeys, thresholds, *expires)
+	if err != nil {
+		return err
+	}
+	keys, err := roleFilesFlag{updater.RoleRoot: *keyFiles}.loadKeys(*passwordFile)
+	if err != nil {
+		return err
+	}
+	if root, err = putRoot(target, root, keys[updater.RoleRoot]); err != nil {
+		return err
+	}
+	fmt.Println("published", updater.RepositoryFile(updater.RoleRoot, root.Version))
+	return nil
+}
+
+// newRootMetadata creates root metadata trusting the public key files of every role.
+func newRootMetadata(publicKeyFiles roleFilesFlag, thresholds map[string]string, expires time.Duration) (root updater.RootMetadata, err error) {
+	root = updater.RootMetadata{Type: updater.RoleRoot, Expires: time.Now().Add(expires).UTC(), Keys: map[string]string{}, Roles: map[string]updater.Role{}}
+	for _, role := range repositoryRoles {
+		if len(publicKeyFiles[role]) == 0 {
+			return updater.RootMetadata{}, fmt.Errorf("no public key for role %s, set -role %s=file", role, role)
+		}
+		r := updater.Role{Threshold: 1}
+		if threshold, found := thresholds[role]; found {
+			if r.Threshold, err = strconv.Atoi(threshold); err != nil || r.Threshold < 1 {
+				return updater.RootMetadata{}, fmt.Errorf("invalid threshold %q of role %s", threshold, role)
+			}
+		}
+		for _, file := range publicKeyFiles[role] {
+			keyId, publicKey, err := readPublicKey(file)
+			if err != nil {
+				return updater.RootMetadata{}, err
+			}
+			root.Keys[keyId] = publicKey
+			r.KeyIds = append(r.KeyIds, keyId)
+		}
+		if r.Threshold > len(r.KeyIds) {
+			return updater.RootMetadata{}, fmt.Errorf("threshold %d of role %s is higher than its %d keys", r.Threshold, role, len(r.KeyIds))
+		}
+		root.Roles[role] = r
+	}
+	for role := range thresholds {
+		if !isRepositoryRole(role) {
+			return updater.RootMetadata{}, fmt.Errorf("unknown role %q", role)
+		}
+	}
+	return root, nil
+}
+
+// readPublicKey reads a minisign public key file and returns its key id and the base64 encoded key.
+func readPublicKey(file string) (keyId string, publicKey string, err error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", "", err
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	publicKey = strings.TrimSpace(lines[len(lines)-1])
+	pub, err := minisign.NewPublicKey(publicKey)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %v", file, err)
+	}
+	return updater.FormatKeyId(pub.KeyId), publicKey, nil
+}
+
+// putRoot publishes root as the version following the current root metadata. Clients load every version, so it is
+// written as tuf/{Version}.root.json before tuf/root.json, the copy of the current version read by the uploader.
+func putRoot(target Target, root updater.RootMetadata, keys []signing.SecretKey) (updater.RootMetadata, error) {
+	if len(keys) == 0 {
+		return updater.RootMetadata{}, errors.New("no key to sign the root metadata, set -key")
+	}
+	var current updater.RootMetadata
+	if err := getMetadata(target, updater.RepositoryFile(updater.RoleRoot, 0), &current); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return updater.RootMetadata{}, err
+	}
+	root.Version = current.Version + 1
+	data, err := signing.SignMetadata(root, keys...)
+	if err != nil {
+		return updater.RootMetadata{}, err
+	}
+	if err = target.Put(updater.RepositoryFile(updater.RoleRoot, root.Version), bytes.NewReader(data)); err != nil {
+		return updater.RootMetadata{}, err
+	}
+	return root, target.Put(updater.RepositoryFile(updater.RoleRoot, 0), bytes.NewReader(data))
+}
+
+func runRefresh(args []string) error {
+	flags := flag.NewFlagSet("refresh", flag.ContinueOnError)
+	targetFlags := addTargetFlags(flags)
+	keyFiles := roleFilesFlag{}
+	flags.Var(keyFiles, "role-key", "secret key of the targets, snapshot or timestamp role as role=file (repeatable)")
+	passwordFile := flags.String("password-file", "", "file containing the password of the secret keys, defaults to $"+passwordEnv+" or a prompt")
+	expires := flags.Duration("expires", defaultRepositoryExpiry, "validity of the targets, snapshot and timestamp metadata")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	target, err := targetFlags.newTarget()
+	if err != nil {
+		return err
+	}
+	keys, err := keyFiles.loadKeys(*passwordFile)
+	if err != nil {
+		return err
+	}
+	if err = putRepository(target, keys, *expires, nil); err != nil {
+		return err
+	}
+	fmt.Println("published", updater.RepositoryFile(updater.RoleTimestamp, 0))
+	return nil
+}
+
+// checkRepository fails if the repository has no root metadata or if keys lack the threshold of keys of the targets,
+// snapshot or timestamp role trusted by it. Called before anything is published.
+func checkRepository(target Target, keys map[string][]signing.SecretKey) error {
+	var root updater.RootMetadata
+	if err := getMetadata(target, updater.RepositoryFile(updater.RoleRoot, 0), &root); err != nil {
+		return fmt.Errorf("no root metadata, publish it with uploader root: %w", err)
+	}
+	for _, role := range repositoryRoles[1:] {
+		if len(keys[role]) == 0 {
+			return fmt.Errorf("no key for role %s, set -role-key %s=file", role, role)
+		}
+		trusted := map[string]bool{}
+		for _, key := range keys[role] {
+			keyId := updater.FormatKeyId(key.KeyId)
+			for _, trustedKeyId := range root.Roles[role].KeyIds {
+				if keyId == trustedKeyId {
+					trusted[keyId] = true
+				}
+			}
+		}
+		threshold := root.Roles[role].Threshold
+		if threshold < 1 {
+			threshold = 1
+		}
+		if len(trusted) < threshold {
+			return fmt.Errorf("%d keys of role %s are trusted by the root metadata, its threshold is %d", len(trusted), role, threshold)
+		}
+	}
+	return nil
+}
+
+// putRepository adds the files to the targets metadata and publishes new versions of the targets, snapshot and
+// timestamp metadata, each signed by the keys of its role. Snapshot and targets metadata are written before the
+// timestamp metadata pinning them.
+func putRepository(target Target, keys map[string][]signing.SecretKey, expires time.Duration, files map[string]updater.TargetFile) error {
+	if err := checkRepository(target, keys); err != nil {
+		return err
+	}
+	targets, snapshot, timestamp, err := getRepository(target)
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(expires).UTC()
+
+	for name, file := range files {
+		targets.Targets[name] = file
+	}
+	targets.Type, targets.Version, targets.Expires = updater.RoleTargets, targets.Version+1, expiresAt
+	if _, err = putMetadata(target, updater.RepositoryFile(updater.RoleTargets, targets.Version), targets, keys[updater.RoleTargets]); err != nil {
+		return err
+	}
+
+	snapshot.Type, snapshot.Version, snapshot.Expires = updater.RoleSnapshot, snapshot.Version+1, expiresAt
+	snapshot.Meta = map[string]updater.MetaFile{updater.RoleTargets + ".json": {Version: targets.Version}}
+	snapshotFile, err := putMetadata(target, updater.RepositoryFile(updater.RoleSnapshot, snapshot.Version), snapshot, keys[updater.RoleSnapshot])
+	if err != nil {
+		return err
+	}
+
+	timestamp.Type, timestamp.Version, timestamp.Expires = updater.RoleTimestamp, timestamp.Version+1, expiresAt
+	timestamp.Meta = map[string]updater.MetaFile{updater.RoleSnapshot + ".json": {Version: snapshot.Version, Length: snapshotFile.Length, Hashes: snapshotFile.Hashes}}
+	_, err = putMetadata(target, updater.RepositoryFile(updater.RoleTimestamp, 0), timestamp, keys[updater.RoleTimestamp])
+	return err
+}
+
+// getRepository reads the current timestamp metadata and the snapshot and targets metadata pinned by it. Without
+// timestamp metadata, the repository is empty.
+func getRepository(target Target) (targets updater.TargetsMetadata, snapshot updater.SnapshotMetadata, timestamp updater.TimestampMetadata, err error) {
+	targets.Targets = map[string]updater.TargetFile{}
+	err = getMetadata(target, updater.RepositoryFile(updater.RoleTimestamp, 0), &timestamp)
+	if errors.Is(err, os.ErrNotExist) {
+		return targets, snapshot, timestamp, nil
+	}
+	if err != nil {
+		return targets, snapshot, timestamp, err
+	}
+	snapshotVersion := timestamp.Meta[updater.RoleSnapshot+".json"].Version
+	if err = getMetadata(target, updater.RepositoryFile(updater.RoleSnapshot, snapshotVersion), &snapshot); err != nil {
+		return targets, snapshot, timestamp, err
+	}
+	targetsVersion := snapshot.Meta[updater.RoleTargets+".json"].Version
+	if err = getMetadata(target, updater.RepositoryFile(updater.RoleTargets, targetsVersion), &targets); err != nil {
+		return targets, snapshot, timestamp, err
+	}
+	if targets.Targets == nil {
+		targets.Targets = map[string]updater.TargetFile{}
+	}
+	return targets, snapshot, timestamp, nil
+}
+
+// getMetadata decodes the signed part of the repository metadata name, without verifying its signatures.
+func getMetadata(target Target, name string, metadata interface{}) error {
+	data, err := target.Get(name)
+	if err != nil {
+		return err
+	}
+	var signed updater.SignedMetadata
+	if err = json.Unmarshal(data, &signed); err != nil {
+		return fmt.Errorf("invalid repository metadata %s: %v", name, err)
+	}
+	if err = json.Unmarshal(signed.Signed, metadata); err != nil {
+		return fmt.Errorf("invalid repository metadata %s: %v", name, err)
+	}
+	return nil
+}
+
+// putMetadata signs and writes metadata to name and returns its length and hashes.
+func putMetadata(target Target, name string, metadata interface{}, keys []signing.SecretKey) (file updater.TargetFile, err error) {
+	data, err := signing.SignMetadata(metadata, keys...)
+	if err != nil {
+		return updater.TargetFile{}, err
+	}
+	if file, err = updater.NewTargetFile(bytes.NewReader(data)); err != nil {
+		return updater.TargetFile{}, err
+	}
+	return file, target.Put(name, bytes.NewReader(data))
+}
+
+// getTargetFiles returns the length and hashes of the files of a release, the published file is read locally and the
+// version json and latest.txt files are given by their content.
+func getTargetFiles(r release, filePath string, metadata map[string][]byte) (files map[string]updater.TargetFile, err error) {
+	payload, err := getTargetFile(r.File)
+	if err != nil {
+		return nil, err
+	}
+	files = map[string]updater.TargetFile{filePath: payload}
+	for name, data := range metadata {
+		if files[name], err = updater.NewTargetFile(bytes.NewReader(data)); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// getTargetFile returns the length and hashes of a local file.
+func getTargetFile(file string) (updater.TargetFile, error) {
+	source, err := os.Open(file)
+	if err != nil {
+		return updater.TargetFile{}, err
+	}
+	defer source.Close()
+	return updater.NewTargetFile(source)
+}
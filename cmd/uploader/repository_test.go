@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"github.com/Flaque/filet"
+	"github.com/haevg-rz/go-updater/internal/signing"
+	"github.com/haevg-rz/go-updater/updater"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestRoleKeys creates a key per role and writes the public keys for newRootMetadata.
+func writeTestRoleKeys(t *testing.T) (keys map[string][]signing.SecretKey, publicKeyFiles roleFilesFlag) {
+	dir := filet.TmpDir(t, "")
+	keys, publicKeyFiles = map[string][]signing.SecretKey{}, roleFilesFlag{}
+	for _, role := range repositoryRoles {
+		key, err := signing.GenerateSecretKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		file := filepath.Join(dir, role+".pub")
+		if err = ioutil.WriteFile(file, []byte(key.EncodePublicKey()), 0644); err != nil {
+			t.Fatal(err)
+		}
+		keys[role], publicKeyFiles[role] = []signing.SecretKey{key}, []string{file}
+	}
+	return keys, publicKeyFiles
+}
+
+func publishTestRepository(t *testing.T, target dirTarget, keys map[string][]signing.SecretKey, version string) {
+	file := filepath.Join(filet.TmpDir(t, ""), "HelloWorld.txt")
+	_ = ioutil.WriteFile(file, []byte("Hello Gophers "+version), 0644)
+	r, err := newRelease("HelloWorld", "beta", version, nil, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTargets := func(files map[string]updater.TargetFile) error {
+		return putRepository(target, keys, time.Hour, files)
+	}
+	if _, err = publish(target, r, nil, addTargets); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPublishRepository(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	target := dirTarget{Dir: filet.TmpDir(t, "")}
+	keys, publicKeyFiles := writeTestRoleKeys(t)
+	root, err := newRootMetadata(publicKeyFiles, nil, time.Hour)
+	assert.NoError(t, err)
+	_, err = putRoot(target, root, keys[updater.RoleRoot])
+	assert.NoError(t, err)
+	repository, err := updater.NewRepository([]byte(readTestFile(t, target, "tuf/1.root.json")))
+	assert.NoError(t, err)
+	asset := updater.Asset{AssetName: "HelloWorld", AssetVersion: "1.0.0", Channel: "beta", Specs: map[string]string{}, Client: updater.LocalClient{CdnBaseUrl: target.Dir}, TargetFolder: filet.TmpDir(t, ""), Repository: repository}
+	_ = ioutil.WriteFile(filepath.Join(asset.TargetFolder, "HelloWorld.txt"), []byte("Hello World"), 0644)
+
+	//act
+	publishTestRepository(t, target, keys, "1.0.1")
+	publishTestRepository(t, target, keys, "1.0.2")
+
+	//assert
+	updatedTo, updated, err := asset.Update()
+	assert.NoError(t, err)
+	assert.True(t, updated)
+	if assert.NotNil(t, updatedTo) {
+		assert.Equal(t, "1.0.2", updatedTo.Version)
+	}
+	got, _ := ioutil.ReadFile(filepath.Join(asset.TargetFolder, "HelloWorld.txt"))
+	assert.Equal(t, "Hello Gophers 1.0.2", string(got))
+	var targets updater.TargetsMetadata
+	assert.NoError(t, getMetadata(target, "tuf/2.targets.json", &targets))
+	assert.Len(t, targets.Targets, 6)
+	assert.Contains(t, targets.Targets, "HelloWorld/beta/1/HelloWorld_1.0.1.txt")
+}
+
+func TestPublishRepositoryFailsBeforeLatest(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	target := dirTarget{Dir: filet.TmpDir(t, "")}
+	keys, publicKeyFiles := writeTestRoleKeys(t)
+	root, _ := newRootMetadata(publicKeyFiles, nil, time.Hour)
+	_, _ = putRoot(target, root, keys[updater.RoleRoot])
+	publishTestRepository(t, target, keys, "1.0.1")
+	file := filet.TmpFile(t, "", "Hello Gophers 1.0.2").Name()
+	r, _ := newRelease("HelloWorld", "beta", "1.0.2", nil, file)
+	errUpload := errors.New("upload failed")
+
+	//act
+	_, err := publish(target, r, nil, func(files map[string]updater.TargetFile) error { return errUpload })
+
+	//assert
+	assert.Equal(t, errUpload, err)
+	assert.Equal(t, "1.0.1", readTestFile(t, target, "HelloWorld/beta/1/latest.txt"))
+}
+
+func Test_checkRepository(t *testing.T) {
+	defer filet.CleanUp(t)
+	keys, publicKeyFiles := writeTestRoleKeys(t)
+	otherKeys, _ := writeTestRoleKeys(t)
+	root, _ := newRootMetadata(publicKeyFiles, nil, time.Hour)
+	withRoot := dirTarget{Dir: filet.TmpDir(t, "")}
+	_, _ = putRoot(withRoot, root, keys[updater.RoleRoot])
+	tests := []struct {
+		name    string
+		target  dirTarget
+		keys    map[string][]signing.SecretKey
+		wantErr bool
+	}{
+		{"trusted keys", withRoot, keys, false},
+		{"no root metadata", dirTarget{Dir: filet.TmpDir(t, "")}, keys, true},
+		{"missing key", withRoot, map[string][]signing.SecretKey{updater.RoleTargets: keys[updater.RoleTargets], updater.RoleSnapshot: keys[updater.RoleSnapshot]}, true},
+		{"untrusted key", withRoot, map[string][]signing.SecretKey{updater.RoleTargets: keys[updater.RoleTargets], updater.RoleSnapshot: keys[updater.RoleSnapshot], updater.RoleTimestamp: otherKeys[updater.RoleTimestamp]}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkRepository(tt.target, tt.keys)
+			assert.Equal(t, tt.wantErr, err != nil, err)
+		})
+	}
+}
+
+func TestRefreshRepository(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	target := dirTarget{Dir: filet.TmpDir(t, "")}
+	keys, publicKeyFiles := writeTestRoleKeys(t)
+	root, _ := newRootMetadata(publicKeyFiles, nil, time.Hour)
+	_, _ = putRoot(target, root, keys[updater.RoleRoot])
+	publishTestRepository(t, target, keys, "1.0.1")
+
+	//act
+	err := putRepository(target, keys, 2*time.Hour, nil)
+
+	//assert
+	assert.NoError(t, err)
+	targets, snapshot, timestamp, err := getRepository(target)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), timestamp.Version)
+	assert.Equal(t, int64(2), snapshot.Version)
+	assert.Equal(t, int64(2), targets.Version)
+	assert.Len(t, targets.Targets, 4)
+	assert.WithinDuration(t, time.Now().Add(2*time.Hour), timestamp.Expires, time.Minute)
+}
+
+func TestPutRootRotation(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	target := dirTarget{Dir: filet.TmpDir(t, "")}
+	keys, publicKeyFiles := writeTestRoleKeys(t)
+	root, _ := newRootMetadata(publicKeyFiles, nil, time.Hour)
+	_, _ = putRoot(target, root, keys[updater.RoleRoot])
+	repository, err := updater.NewRepository([]byte(readTestFile(t, target, "tuf/1.root.json")))
+	assert.NoError(t, err)
+	newKeys, newPublicKeyFiles := writeTestRoleKeys(t)
+	newRoot, _ := newRootMetadata(newPublicKeyFiles, nil, time.Hour)
+	asset := updater.Asset{AssetName: "HelloWorld", AssetVersion: "1.0.0", Channel: "beta", Specs: map[string]string{}, Client: updater.LocalClient{CdnBaseUrl: target.Dir}, TargetFolder: filet.TmpDir(t, ""), Repository: repository}
+
+	//act
+	newRoot, err = putRoot(target, newRoot, append(keys[updater.RoleRoot], newKeys[updater.RoleRoot]...))
+	publishTestRepository(t, target, newKeys, "1.0.1")
+
+	//assert
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), newRoot.Version)
+	assert.Equal(t, readTestFile(t, target, "tuf/2.root.json"), readTestFile(t, target, "tuf/root.json"))
+	_, updateFound, err := asset.CheckForUpdates()
+	assert.NoError(t, err)
+	assert.True(t, updateFound)
+	assert.Equal(t, int64(2), repository.Root().Version)
+}
+
+func Test_newRootMetadata(t *testing.T) {
+	defer filet.CleanUp(t)
+	_, publicKeyFiles := writeTestRoleKeys(t)
+	missingRole := roleFilesFlag{updater.RoleRoot: publicKeyFiles[updater.RoleRoot]}
+	tests := []struct {
+		name       string
+		files      roleFilesFlag
+		thresholds map[string]string
+		wantErr    bool
+	}{
+		{"all roles", publicKeyFiles, nil, false},
+		{"threshold", publicKeyFiles, map[string]string{"targets": "1"}, false},
+		{"missing role", missingRole, nil, true},
+		{"threshold higher than keys", publicKeyFiles, map[string]string{"targets": "2"}, true},
+		{"invalid threshold", publicKeyFiles, map[string]string{"targets": "0"}, true},
+		{"unknown role", publicKeyFiles, map[string]string{"mirrors": "1"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			//act
+			root, err := newRootMetadata(tt.files, tt.thresholds, time.Hour)
+
+			//assert
+			assert.Equal(t, tt.wantErr, err != nil, "got %v", err)
+			if !tt.wantErr {
+				assert.Len(t, root.Keys, 4)
+				assert.Equal(t, 1, root.Roles[updater.RoleTargets].Threshold)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/haevg-rz/go-updater/internal/signing"
+	"github.com/haevg-rz/go-updater/updater"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func runResign(args []string) error {
+	flags := flag.NewFlagSet("resign", flag.ContinueOnError)
+	targetFlags := addTargetFlags(flags)
+	asset := flags.String("asset", "", "name of the asset, e.g. MyApp")
+	channel := flags.String("channel", "", "channel to sign again, e.g. beta")
+	keyFile := flags.String("key", "", "minisign secret key to sign the version jsons and the latest.txt files with")
+	expires := flags.Duration("expires", 0, "validity of the new metadata signatures, e.g. 720h; 0 never expires")
+	passwordFile := flags.String("password-file", "", "file containing the password of the secret key, defaults to $"+passwordEnv+" or a prompt")
+	trustedKeyFiles := &filesFlag{}
+	flags.Var(trustedKeyFiles, "trusted-key", "public key file of a previous signing key, e.g. before uploader rotate, metadata signed by it is signed again by -key (repeatable)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	for name, value := range map[string]string{"-asset": *asset, "-channel": *channel, "-key": *keyFile} {
+		if value == "" {
+			return fmt.Errorf("%s is required", name)
+		}
+	}
+	target, err := targetFlags.newTarget()
+	if err != nil {
+		return err
+	}
+	trustedKeys, err := readTrustedKeys(*trustedKeyFiles)
+	if err != nil {
+		return err
+	}
+	key, err := loadSecretKey(*keyFile, *passwordFile, passwordEnv)
+	if err != nil {
+		return err
+	}
+	signed, err := resign(target, *asset, *channel, *key, trustedKeys, *expires)
+	for _, name := range signed {
+		fmt.Println("signed", name)
+	}
+	return err
+}
+
+// resign signs the latest.txt files of a channel and the version jsons they point to again, so their signatures
+// expire after expiry from now. The files themselves are not changed. Every major up to the one the channel latest.txt
+// points to is signed, clients staying on an older major still check it. All files have to be signed by key or one of
+// the trustedKeys, nothing is signed if one of them is not. Returns the names of the signed files.
+func resign(target Target, asset string, channel string, key signing.SecretKey, trustedKeys []string, expiry time.Duration) (signed []string, err error) {
+	trusted, err := newTrustedKeyRing(key, trustedKeys)
+	if err != nil {
+		return nil, err
+	}
+	// files are verified first and signed in this order, like publish signs version jsons before the latest.txt
+	// pointing to them
+	type metadataFile struct {
+		signed  updater.SignedFile
+		content []byte
+	}
+	var files []metadataFile
+	get := func(s updater.SignedFile) error {
+		content, err := target.Get(s.File)
+		if err != nil {
+			return err
+		}
+		if err = verifySignature(target, content, s, trusted); err != nil {
+			return err
+		}
+		files = append(files, metadataFile{signed: s, content: content})
+		return nil
+	}
+
+	r := release{Asset: asset, Channel: channel, MetadataExpiry: expiry}
+	channelLatest := r.getChannelLatest()
+	channelData, err := target.Get(channelLatest)
+	if err != nil {
+		return nil, err
+	}
+	latestMajor, err := strconv.ParseUint(strings.TrimSpace(string(channelData)), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid major in %s: %v", channelLatest, err)
+	}
+
+	for major := uint64(0); major <= latestMajor; major++ {
+		majorLatest := path.Join(asset, channel, strconv.FormatUint(major, 10), latestFileName)
+		majorData, err := target.Get(majorLatest)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		version, err := updater.ParseVersion(strings.TrimSpace(string(majorData)))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", majorLatest, err)
+		}
+		if version.Major != major {
+			return nil, fmt.Errorf("%s points to version %s of another major", majorLatest, version)
+		}
+		r.Version = version
+		if err = get(r.getSignedMetadata(r.getVersionJson())); err != nil {
+			return nil, err
+		}
+		if err = get(r.getSignedMetadata(majorLatest)); err != nil {
+			return nil, err
+		}
+	}
+	channelSigned := r.getSignedMetadata(channelLatest)
+	channelSigned.Version = ""
+	if err = verifySignature(target, channelData, channelSigned, trusted); err != nil {
+		return nil, err
+	}
+	files = append(files, metadataFile{signed: channelSigned, content: channelData})
+
+	for _, file := range files {
+		if err = putSignature(target, bytes.NewReader(file.content), key, file.signed); err != nil {
+			return signed, err
+		}
+		signed = append(signed, file.signed.File)
+	}
+	return signed, nil
+}
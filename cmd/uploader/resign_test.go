@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"github.com/Flaque/filet"
+	"github.com/haevg-rz/go-updater/internal/signing"
+	"github.com/haevg-rz/go-updater/updater"
+	"github.com/jedisct1/go-minisign"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResign(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	target := dirTarget{Dir: filet.TmpDir(t, "")}
+	keyFile, publicKey := writeTestSecretKey(t)
+	key, err := loadSecretKey(keyFile, "", passwordEnv)
+	assert.NoError(t, err)
+	defer func(pubKey string) { updater.UpdateFilesPubKey = pubKey }(updater.UpdateFilesPubKey)
+	updater.UpdateFilesPubKey = publicKey
+	for _, version := range []string{"1.0.1", "2.0.0"} {
+		r, _ := newRelease("MyApp", "beta", version, nil, filet.TmpFile(t, "", "payload "+version).Name())
+		r.MetadataExpiry = time.Hour
+		if _, err = publish(target, r, key, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fileSignature := readTestFile(t, target, "MyApp/beta/1/MyApp_1.0.1.minisig")
+	asset := updater.Asset{AssetName: "MyApp", AssetVersion: "1.0.0", Channel: "beta", Specs: map[string]string{}, Client: updater.LocalClient{CdnBaseUrl: target.Dir}, RequireSignedMetadata: true}
+
+	//act
+	signed, err := resign(target, "MyApp", "beta", *key, nil, 48*time.Hour)
+
+	//assert
+	assert.NoError(t, err)
+	wantVersions := map[string]string{
+		"MyApp/beta/1/1.0.1.json": "1.0.1",
+		"MyApp/beta/1/latest.txt": "1.0.1",
+		"MyApp/beta/2/2.0.0.json": "2.0.0",
+		"MyApp/beta/2/latest.txt": "2.0.0",
+		"MyApp/beta/latest.txt":   "",
+	}
+	assert.ElementsMatch(t, []string{"MyApp/beta/1/1.0.1.json", "MyApp/beta/1/latest.txt", "MyApp/beta/2/2.0.0.json", "MyApp/beta/2/latest.txt", "MyApp/beta/latest.txt"}, signed)
+	for name, wantVersion := range wantVersions {
+		signature, err := minisign.DecodeSignature(readTestFile(t, target, name+signatureSuffix))
+		assert.NoError(t, err)
+		got, err := updater.ParseTrustedComment(signature.TrustedComment)
+		assert.NoError(t, err)
+		assert.Equal(t, wantVersion, got.Version, name)
+		assert.InDelta(t, time.Now().Add(48*time.Hour).Unix(), got.Expires, 60, name)
+	}
+	assert.Equal(t, fileSignature, readTestFile(t, target, "MyApp/beta/1/MyApp_1.0.1.minisig"))
+	updates, updateFound, err := asset.CheckForUpdates()
+	assert.NoError(t, err)
+	assert.True(t, updateFound)
+	assert.Len(t, updates, 2)
+}
+
+func TestResignMissingChannel(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	target := dirTarget{Dir: filet.TmpDir(t, "")}
+	keyFile, _ := writeTestSecretKey(t)
+	key, err := loadSecretKey(keyFile, "", passwordEnv)
+	assert.NoError(t, err)
+
+	//act
+	_, err = resign(target, "MyApp", "beta", *key, nil, time.Hour)
+
+	//assert
+	assert.Error(t, err)
+}
+
+func TestResignTampered(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	keyFile, _ := writeTestSecretKey(t)
+	key, err := loadSecretKey(keyFile, "", passwordEnv)
+	assert.NoError(t, err)
+	otherKey, err := signing.GenerateSecretKey()
+	assert.NoError(t, err)
+	newTarget := func() dirTarget {
+		target := dirTarget{Dir: filet.TmpDir(t, "")}
+		for _, version := range []string{"1.0.1", "2.0.0"} {
+			r, _ := newRelease("MyApp", "beta", version, nil, filet.TmpFile(t, "", "payload "+version).Name())
+			if _, err = publish(target, r, key, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return target
+	}
+	tamperedVersionJson := newTarget()
+	_ = tamperedVersionJson.Put("MyApp/beta/2/2.0.0.json", strings.NewReader("[]"))
+	rolledBack := newTarget()
+	_ = rolledBack.Put("MyApp/beta/1/1.0.1.json", strings.NewReader(readTestFile(t, rolledBack, "MyApp/beta/2/2.0.0.json")))
+	_ = rolledBack.Put("MyApp/beta/1/1.0.1.json.minisig", strings.NewReader(readTestFile(t, rolledBack, "MyApp/beta/2/2.0.0.json.minisig")))
+	unsigned := newTarget()
+	_ = os.Remove(filepath.Join(unsigned.Dir, "MyApp/beta/latest.txt.minisig"))
+	tests := []struct {
+		name        string
+		target      dirTarget
+		trustedKeys []string
+		signingKey  signing.SecretKey
+		wantErrIs   error
+	}{
+		{"tampered version json", tamperedVersionJson, nil, *key, updater.ErrSignatureInvalid},
+		{"version json of another file", rolledBack, nil, *key, updater.ErrMetadataMismatch},
+		{"missing signature", unsigned, nil, *key, updater.ErrSignatureMissing},
+		{"signed by an untrusted key", newTarget(), nil, otherKey, updater.ErrUnknownKey},
+		{"signed by a trusted previous key", newTarget(), []string{key.PublicKey()}, otherKey, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := readTestFile(t, tt.target, "MyApp/beta/1/latest.txt.minisig")
+
+			//act
+			_, err := resign(tt.target, "MyApp", "beta", tt.signingKey, tt.trustedKeys, time.Hour)
+
+			//assert
+			if tt.wantErrIs == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.True(t, errors.Is(err, tt.wantErrIs), err)
+			assert.Equal(t, before, readTestFile(t, tt.target, "MyApp/beta/1/latest.txt.minisig"))
+		})
+	}
+}
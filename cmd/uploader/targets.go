@@ -0,0 +1,310 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/haevg-rz/go-updater/updater"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Target
+// An updates source the uploader publishes to. Names are slash separated paths relative to the root of the updates
+// source, e.g. "MyApp/beta/1/latest.txt".
+type Target interface {
+	// Put writes content to the file name, creating missing directories.
+	Put(name string, content io.Reader) error
+	// Get reads the file name. The error wraps os.ErrNotExist if the file does not exist.
+	Get(name string) ([]byte, error)
+}
+
+// targetFlags configure the Target of a command.
+type targetFlags struct {
+	target             *string
+	webDav             *bool
+	headers            headersFlag
+	s3Endpoint         *string
+	s3Region           *string
+	s3VirtualHostStyle *bool
+}
+
+func addTargetFlags(flags *flag.FlagSet) targetFlags {
+	t := targetFlags{
+		target:             flags.String("target", "", "updates source: a directory, an http(s):// URL or s3://bucket/prefix"),
+		webDav:             flags.Bool("webdav", false, "create missing collections with MKCOL before uploading to an http(s) target"),
+		headers:            headersFlag{},
+		s3Endpoint:         flags.String("s3-endpoint", "https://s3.amazonaws.com", "endpoint of the S3 compatible storage"),
+		s3Region:           flags.String("s3-region", "", "region of the bucket, defaults to $AWS_REGION"),
+		s3VirtualHostStyle: flags.Bool("s3-virtual-hosted-style", false, "address the bucket as subdomain of the endpoint"),
+	}
+	flags.Var(t.headers, "header", "header of http(s) requests as 'Name: value', e.g. an Authorization header (repeatable)")
+	return t
+}
+
+// newTarget creates the Target for -target. S3 credentials are read from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN. They need s3:GetObject, s3:PutObject and s3:ListBucket, see s3Target.Get.
+func (t targetFlags) newTarget() (Target, error) {
+	target := *t.target
+	switch {
+	case target == "":
+		return nil, errors.New("-target is required")
+	case strings.HasPrefix(target, "http://"), strings.HasPrefix(target, "https://"):
+		return httpTarget{BaseUrl: target, Header: http.Header(t.headers), WebDav: *t.webDav}, nil
+	case strings.HasPrefix(target, "s3://"):
+		bucketAndPrefix := strings.SplitN(strings.TrimPrefix(target, "s3://"), "/", 2)
+		client := updater.S3Client{
+			Endpoint:           *t.s3Endpoint,
+			Region:             *t.s3Region,
+			Bucket:             bucketAndPrefix[0],
+			VirtualHostedStyle: *t.s3VirtualHostStyle,
+		}
+		if len(bucketAndPrefix) == 2 {
+			client.Prefix = bucketAndPrefix[1]
+		}
+		return s3Target{Client: client}, nil
+	}
+	return dirTarget{Dir: target}, nil
+}
+
+// headersFlag collects repeated -header 'Name: value' flags.
+type headersFlag http.Header
+
+func (h headersFlag) String() string {
+	return fmt.Sprint(http.Header(h))
+}
+
+func (h headersFlag) Set(header string) error {
+	parts := strings.SplitN(header, ":", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return fmt.Errorf("header %q is not of the form 'Name: value'", header)
+	}
+	http.Header(h).Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	return nil
+}
+
+// dirTarget publishes into a local directory or mounted file share.
+type dirTarget struct {
+	Dir string
+}
+
+// Put writes the file atomically by renaming a temporary file, so clients never read partial files.
+func (d dirTarget) Put(name string, content io.Reader) error {
+	dest := filepath.Join(d.Dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	tempFile, err := ioutil.TempFile(filepath.Dir(dest), ".upload-*")
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(tempFile, content); err != nil {
+		_ = tempFile.Close()
+		_ = os.Remove(tempFile.Name())
+		return err
+	}
+	if err = tempFile.Close(); err != nil {
+		_ = os.Remove(tempFile.Name())
+		return err
+	}
+	if err = os.Chmod(tempFile.Name(), 0644); err != nil {
+		_ = os.Remove(tempFile.Name())
+		return err
+	}
+	return os.Rename(tempFile.Name(), dest)
+}
+
+func (d dirTarget) Get(name string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(d.Dir, filepath.FromSlash(name)))
+}
+
+// httpTarget publishes with HTTP PUT requests, e.g. to a WebDAV server or a CDN storage API.
+type httpTarget struct {
+	BaseUrl string
+	Header  http.Header
+	// WebDav creates the collections of a file with MKCOL requests before it is uploaded.
+	WebDav bool
+	// Client defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+func (h httpTarget) Put(name string, content io.Reader) error {
+	if h.WebDav {
+		if err := h.makeCollections(path.Dir(name)); err != nil {
+			return err
+		}
+	}
+	req, err := newPutRequest(h.getUrl(name), content)
+	if err != nil {
+		return err
+	}
+	return doRequest(h.getClient(), h.withHeader(req))
+}
+
+func (h httpTarget) Get(name string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, h.getUrl(name), nil)
+	if err != nil {
+		return nil, err
+	}
+	return readResponse(h.getClient(), h.withHeader(req))
+}
+
+// makeCollections creates dir and its parents. Existing collections are answered with 405 Method Not Allowed.
+func (h httpTarget) makeCollections(dir string) error {
+	if dir == "." || dir == "/" {
+		return nil
+	}
+	if err := h.makeCollections(path.Dir(dir)); err != nil {
+		return err
+	}
+	req, err := http.NewRequest("MKCOL", h.getUrl(dir)+"/", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := h.getClient().Do(h.withHeader(req))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode < 300 {
+		return nil
+	}
+	return fmt.Errorf("MKCOL %s: %s", req.URL.Redacted(), resp.Status)
+}
+
+func (h httpTarget) getUrl(name string) string {
+	return joinUrl(h.BaseUrl, name)
+}
+
+func (h httpTarget) withHeader(req *http.Request) *http.Request {
+	for key, values := range h.Header {
+		req.Header[key] = values
+	}
+	return req
+}
+
+func (h httpTarget) getClient() *http.Client {
+	if h.Client == nil {
+		return http.DefaultClient
+	}
+	return h.Client
+}
+
+// s3Target publishes into a bucket of an S3 compatible object storage, signing requests like the updater.S3Client.
+type s3Target struct {
+	Client updater.S3Client
+}
+
+func (s s3Target) Put(name string, content io.Reader) error {
+	bucketUrl, err := s.Client.GetBucketUrl()
+	if err != nil {
+		return err
+	}
+	req, err := newPutRequest(joinUrl(bucketUrl, name), content)
+	if err != nil {
+		return err
+	}
+	if err = s.Client.SignRequest(req, updater.UnsignedPayload); err != nil {
+		return err
+	}
+	return doRequest(s.getClient(), req)
+}
+
+// errForbidden is returned by readResponse for 403 Forbidden answers.
+var errForbidden = errors.New(http.StatusText(http.StatusForbidden))
+
+// Get answers missing files with os.ErrNotExist. S3 only answers 404 for missing objects if the credentials have
+// s3:ListBucket permission on the bucket, otherwise 403, which can not be told apart from a denied read.
+func (s s3Target) Get(name string) ([]byte, error) {
+	bucketUrl, err := s.Client.GetBucketUrl()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodGet, joinUrl(bucketUrl, name), nil)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.Client.SignRequest(req, updater.EmptyPayloadHash); err != nil {
+		return nil, err
+	}
+	data, err := readResponse(s.getClient(), req)
+	if errors.Is(err, errForbidden) {
+		return nil, fmt.Errorf("%w (missing files are answered with 403 without s3:ListBucket permission on the bucket)", err)
+	}
+	return data, err
+}
+
+func (s s3Target) getClient() *http.Client {
+	if s.Client.Http.Client == nil {
+		return http.DefaultClient
+	}
+	return s.Client.Http.Client
+}
+
+// joinUrl example: https://cdn.company.com/updates + MyApp/beta/latest.txt -> https://cdn.company.com/updates/MyApp/beta/latest.txt
+func joinUrl(baseUrl string, name string) string {
+	u, err := url.Parse(baseUrl)
+	if err != nil {
+		return baseUrl + "/" + name
+	}
+	u.Path = path.Join(u.Path, name)
+	return u.String()
+}
+
+// newPutRequest creates a PUT request with a known Content-Length, which object storages require instead of chunked
+// uploads.
+func newPutRequest(url string, content io.Reader) (req *http.Request, err error) {
+	req, err = http.NewRequest(http.MethodPut, url, content)
+	if err != nil {
+		return nil, err
+	}
+	if file, ok := content.(*os.File); ok {
+		info, err := file.Stat()
+		if err != nil {
+			return nil, err
+		}
+		req.ContentLength = info.Size()
+		if req.ContentLength == 0 {
+			req.Body = http.NoBody
+		}
+	}
+	return req, nil
+}
+
+func doRequest(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: %s", req.Method, req.URL.Redacted(), resp.Status)
+	}
+	return nil
+}
+
+// readResponse returns the body of a successful response, 404 Not Found is returned as os.ErrNotExist.
+func readResponse(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%s: %w", req.URL.Redacted(), os.ErrNotExist)
+	}
+	if resp.StatusCode == http.StatusForbidden {
+		return nil, fmt.Errorf("%s %s: %w", req.Method, req.URL.Redacted(), errForbidden)
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s %s: %s", req.Method, req.URL.Redacted(), resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
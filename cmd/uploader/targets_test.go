@@ -0,0 +1,224 @@
+package main
+
+import (
+	"flag"
+	"github.com/Flaque/filet"
+	"github.com/haevg-rz/go-updater/updater"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// memoryStore is an in-memory updates source accepting PUT requests. With webDav, files can only be put into
+// collections created with MKCOL before.
+type memoryStore struct {
+	webDav      bool
+	mutex       sync.Mutex
+	files       map[string][]byte
+	collections map[string]bool
+	puts        []string
+	headers     []http.Header
+}
+
+func newMemoryStore(webDav bool) *memoryStore {
+	return &memoryStore{webDav: webDav, files: map[string][]byte{}, collections: map[string]bool{"/": true}}
+}
+
+func (m *memoryStore) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.headers = append(m.headers, req.Header.Clone())
+	name := path.Clean(req.URL.Path)
+	switch req.Method {
+	case "MKCOL":
+		if m.collections[name] {
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if !m.collections[path.Dir(name)] {
+			rw.WriteHeader(http.StatusConflict)
+			return
+		}
+		m.collections[name] = true
+		rw.WriteHeader(http.StatusCreated)
+	case http.MethodPut:
+		if m.webDav && !m.collections[path.Dir(name)] {
+			rw.WriteHeader(http.StatusConflict)
+			return
+		}
+		data, _ := ioutil.ReadAll(req.Body)
+		if req.ContentLength != int64(len(data)) {
+			rw.WriteHeader(http.StatusLengthRequired)
+			return
+		}
+		m.files[name] = data
+		m.puts = append(m.puts, name)
+		rw.WriteHeader(http.StatusCreated)
+	case http.MethodGet:
+		data, found := m.files[name]
+		if !found {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = rw.Write(data)
+	default:
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func publishTestRelease(t *testing.T, target Target) {
+	file := filet.TmpFile(t, "", "payload").Name()
+	r, err := newRelease("MyApp", "beta", "1.0.1", map[string]string{"Platform": "linux"}, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = publish(target, r, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHttpTargetWebDav(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	store := newMemoryStore(true)
+	store.collections["/updates"] = true
+	server := httptest.NewServer(store)
+	defer server.Close()
+	target := httpTarget{BaseUrl: server.URL + "/updates", Header: http.Header{"Authorization": {"Bearer token"}}, WebDav: true}
+	asset := updater.Asset{AssetName: "MyApp", AssetVersion: "1.0.0", Channel: "beta", Specs: map[string]string{"Platform": "linux"},
+		Client: updater.HttpClient{CdnBaseUrl: server.URL + "/updates"}}
+
+	//act
+	publishTestRelease(t, target)
+	updates, updateFound, err := asset.CheckForUpdates()
+
+	//assert
+	assert.Equal(t, []string{
+		"/updates/MyApp/beta/1/MyApp_1.0.1_linux",
+		"/updates/MyApp/beta/1/1.0.1.json",
+		"/updates/MyApp/beta/1/latest.txt",
+		"/updates/MyApp/beta/latest.txt",
+	}, store.puts)
+	assert.Equal(t, "Bearer token", store.headers[0].Get("Authorization"))
+	assert.NoError(t, err)
+	assert.True(t, updateFound)
+	assert.Len(t, updates, 1)
+}
+
+func TestHttpTargetGetMissing(t *testing.T) {
+	//arrange
+	server := httptest.NewServer(newMemoryStore(false))
+	defer server.Close()
+
+	//act
+	_, err := httpTarget{BaseUrl: server.URL}.Get("MyApp/beta/latest.txt")
+
+	//assert
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestS3Target(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	store := newMemoryStore(false)
+	server := httptest.NewServer(store)
+	defer server.Close()
+	client := updater.S3Client{Endpoint: server.URL, Region: "eu-central-1", Bucket: "releases", Prefix: "updates", AccessKeyId: "minio", SecretAccessKey: "minio123"}
+	asset := updater.Asset{AssetName: "MyApp", AssetVersion: "1.0.0", Channel: "beta", Specs: map[string]string{"Platform": "linux"}, Client: client}
+
+	//act
+	publishTestRelease(t, s3Target{Client: client})
+	updates, updateFound, err := asset.CheckForUpdates()
+
+	//assert
+	assert.Equal(t, "/releases/updates/MyApp/beta/latest.txt", store.puts[len(store.puts)-1])
+	for _, header := range store.headers {
+		assert.True(t, strings.HasPrefix(header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=minio/"))
+	}
+	assert.NoError(t, err)
+	assert.True(t, updateFound)
+	assert.Len(t, updates, 1)
+}
+
+func TestHttpTargetPutsSignaturesLast(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	store := newMemoryStore(false)
+	server := httptest.NewServer(store)
+	defer server.Close()
+	keyFile, _ := writeTestSecretKey(t)
+	key, err := loadSecretKey(keyFile, "", passwordEnv)
+	assert.NoError(t, err)
+	file := filet.TmpFile(t, "", "payload").Name()
+	r, _ := newRelease("MyApp", "beta", "1.0.1", map[string]string{"Platform": "linux"}, file)
+
+	//act
+	_, err = publish(httpTarget{BaseUrl: server.URL}, r, key, nil)
+
+	//assert
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"/MyApp/beta/1/MyApp_1.0.1_linux",
+		"/MyApp/beta/1/MyApp_1.0.1_linux.minisig",
+		"/MyApp/beta/1/1.0.1.json",
+		"/MyApp/beta/1/1.0.1.json.minisig",
+		"/MyApp/beta/1/latest.txt",
+		"/MyApp/beta/1/latest.txt.minisig",
+		"/MyApp/beta/latest.txt",
+		"/MyApp/beta/latest.txt.minisig",
+	}, store.puts)
+}
+
+func TestS3TargetGetForbidden(t *testing.T) {
+	//arrange
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+	target := s3Target{Client: updater.S3Client{Endpoint: server.URL, Region: "eu-central-1", Bucket: "releases", AccessKeyId: "minio", SecretAccessKey: "minio123"}}
+
+	//act
+	_, err := target.Get("MyApp/beta/latest.txt")
+
+	//assert
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, os.ErrNotExist)
+	assert.Contains(t, err.Error(), "s3:ListBucket")
+}
+
+func TestTargetFlags_newTarget(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    Target
+		wantErr bool
+	}{
+		{"directory", []string{"-target", "./updates"}, dirTarget{Dir: "./updates"}, false},
+		{"webdav", []string{"-target", "https://dav.company.local/updates", "-webdav", "-header", "Authorization: Bearer token"},
+			httpTarget{BaseUrl: "https://dav.company.local/updates", WebDav: true, Header: http.Header{"Authorization": {"Bearer token"}}}, false},
+		{"s3", []string{"-target", "s3://releases/updates", "-s3-endpoint", "https://minio.company.local:9000"},
+			s3Target{Client: updater.S3Client{Endpoint: "https://minio.company.local:9000", Bucket: "releases", Prefix: "updates"}}, false},
+		{"missing", []string{}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags := flag.NewFlagSet("test", flag.ContinueOnError)
+			targetFlags := addTargetFlags(flags)
+			if err := flags.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			got, err := targetFlags.newTarget()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("newTarget() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
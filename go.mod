@@ -1,6 +1,6 @@
 module github.com/haevg-rz/go-updater
 
-go 1.13
+go 1.16
 
 require (
 	github.com/Flaque/filet v0.0.0-20201012163910-45f684403088
@@ -9,5 +9,5 @@ require (
 	github.com/sirupsen/logrus v1.8.1
 	github.com/spf13/afero v1.5.1 // indirect
 	github.com/stretchr/testify v1.7.0
-	golang.org/x/crypto v0.0.0-20210220033148-5ea612d1eb83 // indirect
+	golang.org/x/crypto v0.0.0-20210220033148-5ea612d1eb83
 )
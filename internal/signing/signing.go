@@ -0,0 +1,290 @@
+// Package signing creates minisign secret keys and signs updates and metadata with them. It is used by the uploader
+// only, clients verify signatures with the package updater.
+package signing
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/scrypt"
+	"io"
+	"strings"
+)
+
+// ErrIncorrectPassword is returned by DecodeSecretKey if the password does not decrypt the secret key.
+var ErrIncorrectPassword = errors.New("incorrect password for secret key")
+
+var (
+	// legacySignatureAlgorithm is the algorithm id stored in public and secret keys.
+	legacySignatureAlgorithm = [2]byte{'E', 'd'}
+	// prehashedSignatureAlgorithm signs the BLAKE2b-512 hash of the file, like minisign does by default.
+	prehashedSignatureAlgorithm = [2]byte{'E', 'D'}
+	secretKeyKdfAlgorithm       = [2]byte{'S', 'c'}
+	secretKeyChecksumAlgorithm  = [2]byte{'B', '2'}
+	// noKdfAlgorithm marks secret keys stored without encryption, created by "minisign -G -W".
+	noKdfAlgorithm = [2]byte{0, 0}
+)
+
+const (
+	secretKeySaltLength    = 32
+	secretKeyEncodedLength = 2 + 2 + 2 + secretKeySaltLength + 8 + 8 + secretKeyKeyNumLength
+	// secretKeyKeyNumLength is the length of the encrypted part: key id, ed25519 private key and checksum.
+	secretKeyKeyNumLength = 8 + ed25519.PrivateKeySize + blake2b.Size256
+)
+
+// kdfLimits are the scrypt parameters of an encrypted secret key, in the opslimit and memlimit notation of libsodium.
+type kdfLimits struct {
+	opsLimit uint64
+	memLimit uint64
+}
+
+// defaultKdfLimits are the limits used by minisign for new keys.
+var defaultKdfLimits = kdfLimits{opsLimit: 33554432, memLimit: 1073741824}
+
+// secretKeyKdfLimits are used by SecretKey.Encode.
+var secretKeyKdfLimits = defaultKdfLimits
+
+// SecretKey
+// A minisign secret key to sign updates, e.g. by the uploader. Decode it with DecodeSecretKey.
+type SecretKey struct {
+	KeyId      [8]byte
+	PrivateKey ed25519.PrivateKey
+}
+
+// GenerateSecretKey
+// Creates a new random key pair with a random key id.
+func GenerateSecretKey() (key SecretKey, err error) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return SecretKey{}, err
+	}
+	if _, err = rand.Read(key.KeyId[:]); err != nil {
+		return SecretKey{}, err
+	}
+	key.PrivateKey = privateKey
+	return key, nil
+}
+
+// PublicKey
+// Returns the base64 encoded public key, the value of updater.UpdateFilesPubKey and the second line of a minisign public
+// key file.
+func (k SecretKey) PublicKey() string {
+	publicKey := k.PrivateKey.Public().(ed25519.PublicKey)
+	return base64.StdEncoding.EncodeToString(append(append(append([]byte{}, legacySignatureAlgorithm[:]...), k.KeyId[:]...), publicKey...))
+}
+
+// EncodePublicKey
+// Returns the content of a minisign public key file.
+func (k SecretKey) EncodePublicKey() string {
+	return fmt.Sprintf("untrusted comment: minisign public key %s\n%s\n", formatKeyId(k.KeyId), k.PublicKey())
+}
+
+// Encode
+// Returns the content of a minisign secret key file, encrypted with password using the scrypt parameters of minisign.
+// The key is stored unencrypted if password is empty.
+func (k SecretKey) Encode(password []byte) (encoded string, err error) {
+	return encodeSecretKey(k, password, secretKeyKdfLimits)
+}
+
+// DecodeSecretKey
+// Decodes the content of a minisign secret key file, decrypting it with password. Unencrypted keys ignore password.
+func DecodeSecretKey(encoded string, password []byte) (key SecretKey, err error) {
+	data, err := decodeKeyFile(encoded)
+	if err != nil {
+		return SecretKey{}, err
+	}
+	if len(data) != secretKeyEncodedLength {
+		return SecretKey{}, errors.New("invalid secret key length")
+	}
+	var signatureAlgorithm, kdfAlgorithm, checksumAlgorithm [2]byte
+	copy(signatureAlgorithm[:], data[0:2])
+	copy(kdfAlgorithm[:], data[2:4])
+	copy(checksumAlgorithm[:], data[4:6])
+	if signatureAlgorithm != legacySignatureAlgorithm {
+		return SecretKey{}, errors.New("unsupported secret key signature algorithm")
+	}
+	if checksumAlgorithm != secretKeyChecksumAlgorithm {
+		return SecretKey{}, errors.New("unsupported secret key checksum algorithm")
+	}
+	salt := data[6 : 6+secretKeySaltLength]
+	limits := kdfLimits{
+		opsLimit: binary.LittleEndian.Uint64(data[6+secretKeySaltLength:]),
+		memLimit: binary.LittleEndian.Uint64(data[14+secretKeySaltLength:]),
+	}
+	keyNum := append([]byte{}, data[22+secretKeySaltLength:]...)
+
+	switch kdfAlgorithm {
+	case noKdfAlgorithm:
+	case secretKeyKdfAlgorithm:
+		stream, err := deriveKeyStream(password, salt, limits)
+		if err != nil {
+			return SecretKey{}, err
+		}
+		for i := range keyNum {
+			keyNum[i] ^= stream[i]
+		}
+	default:
+		return SecretKey{}, errors.New("unsupported secret key kdf algorithm")
+	}
+
+	copy(key.KeyId[:], keyNum[:8])
+	key.PrivateKey = ed25519.PrivateKey(keyNum[8 : 8+ed25519.PrivateKeySize])
+	checksum := key.getChecksum()
+	if subtle.ConstantTimeCompare(checksum[:], keyNum[8+ed25519.PrivateKeySize:]) != 1 {
+		if kdfAlgorithm == noKdfAlgorithm {
+			return SecretKey{}, errors.New("invalid secret key checksum")
+		}
+		return SecretKey{}, ErrIncorrectPassword
+	}
+	return key, nil
+}
+
+// Sign
+// Creates a minisign signature of the content read from r, prehashed with BLAKE2b-512 like minisign does by default.
+// The trusted comment is signed as well, e.g. "timestamp:1612345678\tfile:MyApp_1.2.3.exe\thashed".
+func (k SecretKey) Sign(r io.Reader, trustedComment string) (signature string, err error) {
+	if strings.ContainsAny(trustedComment, "\r\n") {
+		return "", errors.New("trusted comment must be a single line")
+	}
+	hash, err := blake2b.New512(nil)
+	if err != nil {
+		return "", err
+	}
+	if _, err = io.Copy(hash, r); err != nil {
+		return "", err
+	}
+	sig := ed25519.Sign(k.PrivateKey, hash.Sum(nil))
+	globalSig := ed25519.Sign(k.PrivateKey, append(append([]byte{}, sig...), trustedComment...))
+
+	encodedSig := append(append(append([]byte{}, prehashedSignatureAlgorithm[:]...), k.KeyId[:]...), sig...)
+	return fmt.Sprintf("untrusted comment: signature from go-updater secret key\n%s\ntrusted comment: %s\n%s\n",
+		base64.StdEncoding.EncodeToString(encodedSig),
+		trustedComment,
+		base64.StdEncoding.EncodeToString(globalSig)), nil
+}
+
+// SignMetadata
+// Marshals metadata of a repository and signs it with every key, in the format read by updater.SignedMetadata.
+func SignMetadata(metadata interface{}, keys ...SecretKey) (data []byte, err error) {
+	signed, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, err
+	}
+	envelope := signedMetadata{Signed: signed, Signatures: []metadataSignature{}}
+	for _, key := range keys {
+		envelope.Signatures = append(envelope.Signatures, metadataSignature{
+			KeyId:     formatKeyId(key.KeyId),
+			Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(key.PrivateKey, signed)),
+		})
+	}
+	return json.MarshalIndent(envelope, "", "  ")
+}
+
+// signedMetadata and metadataSignature are marshaled like updater.SignedMetadata and updater.MetadataSignature.
+type signedMetadata struct {
+	Signed     json.RawMessage     `json:"signed"`
+	Signatures []metadataSignature `json:"signatures"`
+}
+
+type metadataSignature struct {
+	KeyId     string `json:"keyid"`
+	Signature string `json:"sig"`
+}
+
+// encodeSecretKey encrypts the key with password in the format of minisign secret key files. Without password the key
+// is stored unencrypted, like "minisign -G -W" does.
+func encodeSecretKey(k SecretKey, password []byte, limits kdfLimits) (encoded string, err error) {
+	salt := make([]byte, secretKeySaltLength)
+	checksum := k.getChecksum()
+	keyNum := append(append(append([]byte{}, k.KeyId[:]...), k.PrivateKey...), checksum[:]...)
+	kdfAlgorithm, comment := noKdfAlgorithm, "minisign secret key"
+	if len(password) > 0 {
+		kdfAlgorithm, comment = secretKeyKdfAlgorithm, "minisign encrypted secret key"
+		if _, err = rand.Read(salt); err != nil {
+			return "", err
+		}
+		stream, err := deriveKeyStream(password, salt, limits)
+		if err != nil {
+			return "", err
+		}
+		for i := range keyNum {
+			keyNum[i] ^= stream[i]
+		}
+	} else {
+		limits = kdfLimits{}
+	}
+
+	data := bytes.NewBuffer(nil)
+	data.Write(legacySignatureAlgorithm[:])
+	data.Write(kdfAlgorithm[:])
+	data.Write(secretKeyChecksumAlgorithm[:])
+	data.Write(salt)
+	_ = binary.Write(data, binary.LittleEndian, limits.opsLimit)
+	_ = binary.Write(data, binary.LittleEndian, limits.memLimit)
+	data.Write(keyNum)
+	return fmt.Sprintf("untrusted comment: %s\n%s\n", comment, base64.StdEncoding.EncodeToString(data.Bytes())), nil
+}
+
+// getChecksum is the BLAKE2b-256 hash of signature algorithm, key id and private key.
+func (k SecretKey) getChecksum() [blake2b.Size256]byte {
+	content := append(append(append([]byte{}, legacySignatureAlgorithm[:]...), k.KeyId[:]...), k.PrivateKey...)
+	return blake2b.Sum256(content)
+}
+
+// deriveKeyStream derives the stream XORed with the secret key, like crypto_pwhash_scryptsalsa208sha256 of libsodium.
+func deriveKeyStream(password []byte, salt []byte, limits kdfLimits) (stream []byte, err error) {
+	n, r, p := getScryptParameters(limits)
+	return scrypt.Key(password, salt, n, r, p, secretKeyKeyNumLength)
+}
+
+// getScryptParameters converts opslimit and memlimit into the scrypt parameters N, r and p, as libsodium does.
+func getScryptParameters(limits kdfLimits) (n int, r int, p int) {
+	const minOpsLimit = 32768
+	opsLimit := limits.opsLimit
+	if opsLimit < minOpsLimit {
+		opsLimit = minOpsLimit
+	}
+	r = 8
+	var maxN uint64
+	if opsLimit < limits.memLimit/32 {
+		p = 1
+		maxN = opsLimit / (uint64(r) * 4)
+	} else {
+		maxN = limits.memLimit / (uint64(r) * 128)
+	}
+	nLog2 := uint(1)
+	for ; nLog2 < 63; nLog2++ {
+		if uint64(1)<<nLog2 > maxN/2 {
+			break
+		}
+	}
+	if p == 0 {
+		maxRp := (opsLimit / 4) / (uint64(1) << nLog2)
+		if maxRp > 0x3fffffff {
+			maxRp = 0x3fffffff
+		}
+		p = int(maxRp) / r
+	}
+	return 1 << nLog2, r, p
+}
+
+// decodeKeyFile returns the base64 decoded key of a minisign key file, skipping the untrusted comment.
+func decodeKeyFile(encoded string) (data []byte, err error) {
+	lines := strings.Split(strings.TrimSpace(strings.ReplaceAll(encoded, "\r\n", "\n")), "\n")
+	if len(lines) < 2 {
+		return nil, errors.New("incomplete key file")
+	}
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+}
+
+// formatKeyId formats a key id like minisign and updater.FormatKeyId, e.g. "E3B0C44298FC1C14".
+func formatKeyId(keyId [8]byte) string {
+	return fmt.Sprintf("%016X", binary.LittleEndian.Uint64(keyId[:]))
+}
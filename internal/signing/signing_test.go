@@ -0,0 +1,113 @@
+package signing
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/haevg-rz/go-updater/updater"
+	"github.com/jedisct1/go-minisign"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+// testKdfLimits keep scrypt fast in tests, minisign uses defaultKdfLimits.
+var testKdfLimits = kdfLimits{opsLimit: 32768, memLimit: 16777216}
+
+func TestDecodeSecretKeyRoundTrip(t *testing.T) {
+	//arrange
+	key, _ := GenerateSecretKey()
+	encoded, err := encodeSecretKey(key, []byte("secret"), testKdfLimits)
+	assert.NoError(t, err)
+
+	//act
+	decoded, err := DecodeSecretKey(encoded, []byte("secret"))
+
+	//assert
+	assert.NoError(t, err)
+	assert.Equal(t, key, decoded)
+	assert.True(t, strings.HasPrefix(encoded, "untrusted comment: minisign encrypted secret key\n"))
+}
+
+func TestDecodeSecretKeyIncorrectPassword(t *testing.T) {
+	//arrange
+	key, _ := GenerateSecretKey()
+	encoded, err := encodeSecretKey(key, []byte("secret"), testKdfLimits)
+	assert.NoError(t, err)
+
+	//act
+	_, err = DecodeSecretKey(encoded, []byte("wrong"))
+
+	//assert
+	assert.True(t, errors.Is(err, ErrIncorrectPassword))
+}
+
+func TestDecodeSecretKeyUnencrypted(t *testing.T) {
+	//arrange
+	key, _ := GenerateSecretKey()
+	encoded, err := key.Encode(nil)
+	assert.NoError(t, err)
+
+	//act
+	decoded, err := DecodeSecretKey(encoded, []byte("ignored"))
+
+	//assert
+	assert.NoError(t, err)
+	assert.Equal(t, key, decoded)
+}
+
+func TestSecretKey_EncodePublicKey(t *testing.T) {
+	//arrange
+	key, _ := GenerateSecretKey()
+	key.KeyId = [8]byte{0x14, 0x1c, 0xfc, 0x98, 0x42, 0xc4, 0xb0, 0xe3}
+
+	//act
+	encoded := key.EncodePublicKey()
+
+	//assert
+	assert.True(t, strings.HasPrefix(encoded, "untrusted comment: minisign public key E3B0C44298FC1C14\n"))
+	pub, err := minisign.DecodePublicKey(encoded)
+	assert.NoError(t, err)
+	assert.Equal(t, key.KeyId, pub.KeyId)
+}
+
+func Test_getScryptParameters(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits kdfLimits
+		n      int
+		r      int
+		p      int
+	}{
+		{"minisign default", defaultKdfLimits, 1 << 20, 8, 1},
+		{"libsodium interactive", kdfLimits{opsLimit: 524288, memLimit: 16777216}, 1 << 14, 8, 1},
+		{"tests", testKdfLimits, 1 << 10, 8, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, r, p := getScryptParameters(tt.limits)
+			assert.Equal(t, []int{tt.n, tt.r, tt.p}, []int{n, r, p})
+		})
+	}
+}
+
+func TestSignMetadata(t *testing.T) {
+	//arrange
+	first, _ := GenerateSecretKey()
+	second, _ := GenerateSecretKey()
+	keys := []SecretKey{first, second}
+
+	//act
+	data, err := SignMetadata(updater.TargetsMetadata{Type: updater.RoleTargets, Version: 1}, keys...)
+
+	//assert
+	assert.NoError(t, err)
+	var envelope updater.SignedMetadata
+	assert.NoError(t, json.Unmarshal(data, &envelope))
+	if assert.Len(t, envelope.Signatures, 2) {
+		assert.Equal(t, updater.FormatKeyId(keys[0].KeyId), envelope.Signatures[0].KeyId)
+		assert.Equal(t, updater.FormatKeyId(keys[1].KeyId), envelope.Signatures[1].KeyId)
+	}
+	var targets updater.TargetsMetadata
+	assert.NoError(t, json.Unmarshal(envelope.Signed, &targets))
+	assert.Equal(t, int64(1), targets.Version)
+}
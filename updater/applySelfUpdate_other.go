@@ -0,0 +1,13 @@
+//go:build !windows && !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !windows,!aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package updater
+
+import (
+	"errors"
+	"runtime"
+)
+
+func (a Asset) applySelfUpdate(updateFile string) error {
+	return errors.New("self update is not supported on " + runtime.GOOS)
+}
@@ -0,0 +1,122 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package updater
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// execSelf replaces the running process image with the updated executable.
+var execSelf = syscall.Exec
+
+// runningExecutable resolves the executable replaced by applySelfUpdate.
+var runningExecutable = getRunningExecutable
+
+func (a Asset) applySelfUpdate(updateFile string) error {
+	executable, err := runningExecutable()
+	if err != nil {
+		return err
+	}
+	if err = replaceExecutable(executable, updateFile); err != nil {
+		return err
+	}
+	return execSelf(executable, os.Args, os.Environ())
+}
+
+// getRunningExecutable resolves the path of the running binary, following symlinks so the real file is replaced.
+func getRunningExecutable() (executable string, err error) {
+	executable, err = os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(executable)
+}
+
+// replaceExecutable atomically exchanges executable with updateFile. The new file is staged next to the executable,
+// gets the mode bits and ownership of the old one and is then renamed over it. The old binary is kept as backup.
+func replaceExecutable(executable string, updateFile string) (err error) {
+	info, err := os.Stat(executable)
+	if err != nil {
+		return err
+	}
+
+	stagedFile := filepath.Join(filepath.Dir(executable), "."+filepath.Base(executable)+".new")
+	if err = copyExecutable(updateFile, stagedFile, info); err != nil {
+		_ = os.Remove(stagedFile)
+		return err
+	}
+
+	backUpFile := executable + ".old"
+	_ = os.Remove(backUpFile)
+	if err = os.Link(executable, backUpFile); err != nil {
+		log.Println("could not keep a backup of", executable, err)
+	}
+
+	if err = os.Rename(stagedFile, executable); err != nil {
+		_ = os.Remove(stagedFile)
+		return err
+	}
+	syncDir(filepath.Dir(executable))
+	return os.Remove(updateFile)
+}
+
+func copyExecutable(src string, dest string, info os.FileInfo) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	if _, err = io.Copy(out, in); err != nil {
+		return err
+	}
+	// chown clears the setuid and setgid bits on Linux, so the mode is set afterwards.
+	if err = chownLike(out, info); err != nil {
+		return err
+	}
+	if err = out.Chmod(info.Mode()); err != nil {
+		return err
+	}
+	return out.Sync()
+}
+
+// chownLike gives file the owner and group of info. Nothing is done if they already match, so unprivileged
+// processes can update binaries they own.
+func chownLike(file *os.File, info os.FileInfo) error {
+	want, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	current, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if have, ok := current.Sys().(*syscall.Stat_t); ok && have.Uid == want.Uid && have.Gid == want.Gid {
+		return nil
+	}
+	return file.Chown(int(want.Uid), int(want.Gid))
+}
+
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = d.Sync()
+	_ = d.Close()
+}
@@ -0,0 +1,105 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package updater
+
+import (
+	"github.com/Flaque/filet"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_replaceExecutable(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	dir := filet.TmpDir(t, "")
+	executable := filepath.Join(dir, "myCore")
+	updateFile := filepath.Join(dir, "update_myCore_1.0.1")
+	assert.NoError(t, ioutil.WriteFile(executable, []byte("old"), 0750))
+	assert.NoError(t, ioutil.WriteFile(updateFile, []byte("new"), 0600))
+	assert.NoError(t, os.Chmod(executable, 0750))
+
+	//act
+	err := replaceExecutable(executable, updateFile)
+
+	//assert
+	assert.NoError(t, err)
+	content, _ := ioutil.ReadFile(executable)
+	assert.Equal(t, "new", string(content))
+	info, _ := os.Stat(executable)
+	assert.Equal(t, os.FileMode(0750), info.Mode().Perm())
+	backUp, _ := ioutil.ReadFile(executable + ".old")
+	assert.Equal(t, "old", string(backUp))
+	_, err = os.Stat(updateFile)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func Test_replaceExecutableMissingUpdate(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	dir := filet.TmpDir(t, "")
+	executable := filepath.Join(dir, "myCore")
+	assert.NoError(t, ioutil.WriteFile(executable, []byte("old"), 0750))
+
+	//act
+	err := replaceExecutable(executable, filepath.Join(dir, "missing"))
+
+	//assert
+	assert.Error(t, err)
+	content, _ := ioutil.ReadFile(executable)
+	assert.Equal(t, "old", string(content))
+	_, err = os.Stat(filepath.Join(dir, ".myCore.new"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func Test_replaceExecutableKeepsSetuid(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	dir := filet.TmpDir(t, "")
+	executable := filepath.Join(dir, "myCore")
+	updateFile := filepath.Join(dir, "update_myCore_1.0.1")
+	assert.NoError(t, ioutil.WriteFile(executable, []byte("old"), 0750))
+	assert.NoError(t, ioutil.WriteFile(updateFile, []byte("new"), 0600))
+	assert.NoError(t, os.Chmod(executable, 0750|os.ModeSetuid))
+
+	//act
+	err := replaceExecutable(executable, updateFile)
+
+	//assert
+	assert.NoError(t, err)
+	info, _ := os.Stat(executable)
+	assert.Equal(t, 0750|os.ModeSetuid, info.Mode()&(os.ModePerm|os.ModeSetuid))
+}
+
+func TestAsset_applySelfUpdateExecsUpdatedExecutable(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	dir := filet.TmpDir(t, "")
+	executable := filepath.Join(dir, "myCore")
+	updateFile := filepath.Join(dir, "update_myCore_1.0.1")
+	assert.NoError(t, ioutil.WriteFile(executable, []byte("old"), 0750))
+	assert.NoError(t, ioutil.WriteFile(updateFile, []byte("new"), 0600))
+	defer func(exec func(string, []string, []string) error, running func() (string, error)) {
+		execSelf, runningExecutable = exec, running
+	}(execSelf, runningExecutable)
+	runningExecutable = func() (string, error) { return executable, nil }
+	var gotArgv0 string
+	var gotArgs []string
+	execSelf = func(argv0 string, argv []string, envv []string) error {
+		gotArgv0, gotArgs = argv0, argv
+		content, _ := ioutil.ReadFile(argv0)
+		assert.Equal(t, "new", string(content))
+		return nil
+	}
+
+	//act
+	err := Asset{}.applySelfUpdate(updateFile)
+
+	//assert
+	assert.NoError(t, err)
+	assert.Equal(t, executable, gotArgv0)
+	assert.Equal(t, os.Args, gotArgs)
+}
@@ -0,0 +1,66 @@
+package updater
+
+import (
+	"archive/zip"
+	"context"
+	"io/fs"
+)
+
+// BundleResult
+// The outcome of ImportBundle for one Asset. Err is set if the update of this asset failed, Updated is false if the
+// bundle contains no newer version for it.
+type BundleResult struct {
+	AssetName string
+	UpdatedTo *UpdateInfo
+	Updated   bool
+	Err       error
+}
+
+// ImportBundle
+// Applies updates from an offline bundle, a zip file containing the {AssetName}/{Channel}/... tree of the updates
+// source with latest.txt files, version jsons, updates and their .minisig signatures. The tree may also be wrapped in a
+// single directory, like a zipped updates directory. Every asset is updated exactly as Update would from the CDN,
+// updates are verified with the KeyRing of the asset, which defaults to UpdateFilesPubKey, or with its Repository when
+// set. An error is only returned if the bundle can not be read, failures of single assets are reported in their
+// BundleResult.
+func ImportBundle(bundleFile string, assets ...Asset) (results []BundleResult, err error) {
+	return ImportBundleContext(context.Background(), bundleFile, assets...)
+}
+
+// ImportBundleContext
+// Like ImportBundle, importing is canceled when ctx is done.
+func ImportBundleContext(ctx context.Context, bundleFile string, assets ...Asset) (results []BundleResult, err error) {
+	bundle, err := zip.OpenReader(bundleFile)
+	if err != nil {
+		return nil, err
+	}
+	defer bundle.Close()
+
+	root, err := getBundleRoot(bundle, assets)
+	if err != nil {
+		return nil, err
+	}
+	for _, asset := range assets {
+		asset.Client = FSClient{FS: root}
+		updatedTo, updated, err := asset.UpdateContext(ctx)
+		results = append(results, BundleResult{AssetName: asset.AssetName, UpdatedTo: updatedTo, Updated: updated, Err: err})
+	}
+	return results, nil
+}
+
+// getBundleRoot returns the single top-level directory of the bundle, unless it is the folder of one of the assets.
+func getBundleRoot(bundle fs.FS, assets []Asset) (root fs.FS, err error) {
+	entries, err := fs.ReadDir(bundle, ".")
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) != 1 || !entries[0].IsDir() {
+		return bundle, nil
+	}
+	for _, asset := range assets {
+		if asset.AssetName == entries[0].Name() {
+			return bundle, nil
+		}
+	}
+	return fs.Sub(bundle, entries[0].Name())
+}
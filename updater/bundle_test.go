@@ -0,0 +1,120 @@
+package updater
+
+import (
+	"archive/zip"
+	"github.com/Flaque/filet"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestBundle(t *testing.T, files map[string]string) string {
+	bundleFile := filepath.Join(filet.TmpDir(t, ""), "bundle.zip")
+	file, err := os.Create(bundleFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := zip.NewWriter(file)
+	for name, content := range files {
+		entry, _ := archive.Create(name)
+		_, _ = entry.Write([]byte(content))
+	}
+	_ = archive.Close()
+	_ = file.Close()
+	return bundleFile
+}
+
+func TestImportBundle(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	key := newTestSecretKey(t)
+	defer func(pubKey string) { UpdateFilesPubKey = pubKey }(UpdateFilesPubKey)
+	UpdateFilesPubKey = key.PublicKey()
+	signature := signTestContent(t, key, prehashedSignatureAlgorithm, "Hello Gophers", "timestamp:1")
+	files := testUpdateFiles("HelloWorld", "1.0.1")
+	files["HelloWorld/beta/1/HelloWorld_1.0.1.txt.minisig"] = signature
+	for name, content := range testUpdateFiles("Tampered", "1.0.1") {
+		files[name] = content
+	}
+	files["Tampered/beta/1/Tampered_1.0.1.txt"] = "Hello Attackers"
+	files["Tampered/beta/1/Tampered_1.0.1.txt.minisig"] = signature
+	bundleFile := writeTestBundle(t, files)
+	newAsset := func(name string) Asset {
+		targetFolder := filet.TmpDir(t, "")
+		_ = ioutil.WriteFile(filepath.Join(targetFolder, name+".txt"), []byte("Hello World"), 0644)
+		return Asset{AssetName: name, AssetVersion: "1.0.0", Channel: "beta", Specs: map[string]string{}, TargetFolder: targetFolder}
+	}
+	helloWorld, tampered, missing := newAsset("HelloWorld"), newAsset("Tampered"), newAsset("Missing")
+
+	//act
+	results, err := ImportBundle(bundleFile, helloWorld, tampered, missing)
+
+	//assert
+	assert.NoError(t, err)
+	if !assert.Len(t, results, 3) {
+		return
+	}
+	assert.NoError(t, results[0].Err)
+	assert.True(t, results[0].Updated)
+	assert.Equal(t, "1.0.1", results[0].UpdatedTo.Version)
+	got, _ := ioutil.ReadFile(filepath.Join(helloWorld.TargetFolder, "HelloWorld.txt"))
+	assert.Equal(t, "Hello Gophers", string(got))
+	assert.Equal(t, "1.0.1", GetVersion(helloWorld.TargetFolder, "HelloWorld"))
+
+	assert.False(t, results[1].Updated)
+	got, _ = ioutil.ReadFile(filepath.Join(tampered.TargetFolder, "Tampered.txt"))
+	assert.Equal(t, "Hello World", string(got))
+
+	assert.Error(t, results[2].Err)
+	assert.False(t, results[2].Updated)
+}
+
+func TestImportBundleMissingFile(t *testing.T) {
+	//act
+	results, err := ImportBundle(filepath.Join(os.TempDir(), "does-not-exist.zip"), Asset{AssetName: "HelloWorld"})
+
+	//assert
+	assert.Error(t, err)
+	assert.Nil(t, results)
+}
+
+func TestImportBundleTopLevelDirectory(t *testing.T) {
+	defer filet.CleanUp(t)
+	key := newTestSecretKey(t)
+	defer func(pubKey string) { UpdateFilesPubKey = pubKey }(UpdateFilesPubKey)
+	UpdateFilesPubKey = key.PublicKey()
+	files := testUpdateFiles("HelloWorld", "1.0.1")
+	files["HelloWorld/beta/1/HelloWorld_1.0.1.txt.minisig"] = signTestContent(t, key, prehashedSignatureAlgorithm, "Hello Gophers", "timestamp:1")
+	tests := []struct {
+		name   string
+		prefix string
+	}{
+		{"asset folders at the root", ""},
+		{"zipped updates directory", "updates/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			//arrange
+			prefixed := map[string]string{}
+			for name, content := range files {
+				prefixed[tt.prefix+name] = content
+			}
+			bundleFile := writeTestBundle(t, prefixed)
+			targetFolder := filet.TmpDir(t, "")
+			_ = ioutil.WriteFile(filepath.Join(targetFolder, "HelloWorld.txt"), []byte("Hello World"), 0644)
+			asset := Asset{AssetName: "HelloWorld", AssetVersion: "1.0.0", Channel: "beta", Specs: map[string]string{}, TargetFolder: targetFolder}
+
+			//act
+			results, err := ImportBundle(bundleFile, asset)
+
+			//assert
+			assert.NoError(t, err)
+			if assert.Len(t, results, 1) {
+				assert.NoError(t, results[0].Err)
+				assert.True(t, results[0].Updated)
+			}
+		})
+	}
+}
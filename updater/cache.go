@@ -0,0 +1,57 @@
+package updater
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// MetadataCache
+// Persists responses of HttpClient.readData (latest.txt, version jsons, signatures) in Dir, keyed by URL. Cached
+// responses are revalidated with If-None-Match and If-Modified-Since and reused if the server answers 304 Not Modified.
+type MetadataCache struct {
+	Dir string
+}
+
+type cacheEntry struct {
+	Url          string
+	ETag         string
+	LastModified string
+	Body         []byte
+}
+
+func (c MetadataCache) get(url string) (entry cacheEntry, found bool) {
+	data, err := ioutil.ReadFile(c.getPathToEntry(url))
+	if err != nil {
+		return cacheEntry{}, false
+	}
+	if err = json.Unmarshal(data, &entry); err != nil || entry.Url != url {
+		return cacheEntry{}, false
+	}
+	return entry, true
+}
+
+// put stores entry atomically, so concurrent readers never see partial entries.
+func (c MetadataCache) put(entry cacheEntry) error {
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return err
+	}
+	content, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.getPathToEntry(entry.Url), ".entry-*", content)
+}
+
+func (c MetadataCache) remove(url string) {
+	_ = os.Remove(c.getPathToEntry(url))
+}
+
+//getPathToEntry example: cache\3f0a...e1.json
+func (c MetadataCache) getPathToEntry(url string) string {
+	hash := sha256.Sum256([]byte(url))
+	return filepath.Join(c.Dir, hex.EncodeToString(hash[:])+".json")
+}
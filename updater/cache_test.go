@@ -0,0 +1,97 @@
+package updater
+
+import (
+	"context"
+	"github.com/Flaque/filet"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type conditionalServer struct {
+	content      string
+	etag         string
+	lastModified time.Time
+	conditional  []string
+	notModified  int
+}
+
+func (c *conditionalServer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	c.conditional = append(c.conditional, req.Header.Get("If-None-Match")+req.Header.Get("If-Modified-Since"))
+	if c.etag != "" {
+		rw.Header().Set("ETag", c.etag)
+	}
+	if req.Header.Get("If-None-Match") == c.etag && c.etag != "" {
+		c.notModified++
+	}
+	http.ServeContent(rw, req, "", c.lastModified, strings.NewReader(c.content))
+}
+
+func TestHttpClientReadDataUsesCache(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	cacheDir := filet.TmpDir(t, "")
+	server := &conditionalServer{content: "1", etag: `"a"`}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	newClient := func() HttpClient {
+		return HttpClient{CdnBaseUrl: httpServer.URL, Client: httpServer.Client(), Cache: &MetadataCache{Dir: cacheDir}}
+	}
+
+	//act
+	first, errFirst := newClient().readData(context.Background(), "MyApp/beta/latest.txt")
+	second, errSecond := newClient().readData(context.Background(), "MyApp/beta/latest.txt")
+	server.content, server.etag = "2", `"b"`
+	third, errThird := newClient().readData(context.Background(), "MyApp/beta/latest.txt")
+
+	//assert
+	assert.NoError(t, errFirst)
+	assert.NoError(t, errSecond)
+	assert.NoError(t, errThird)
+	assert.Equal(t, "1", string(first))
+	assert.Equal(t, "1", string(second))
+	assert.Equal(t, "2", string(third))
+	assert.Equal(t, []string{"", `"a"`, `"a"`}, server.conditional)
+	assert.Equal(t, 1, server.notModified)
+}
+
+func TestHttpClientReadDataRevalidatesLastModified(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	lastModified := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	server := &conditionalServer{content: "1.0.1", lastModified: lastModified}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	client := HttpClient{CdnBaseUrl: httpServer.URL, Client: httpServer.Client(), Cache: &MetadataCache{Dir: filet.TmpDir(t, "")}}
+
+	//act
+	_, _ = client.readData(context.Background(), "MyApp/beta/1/latest.txt")
+	got, err := client.readData(context.Background(), "MyApp/beta/1/latest.txt")
+
+	//assert
+	assert.NoError(t, err)
+	assert.Equal(t, "1.0.1", string(got))
+	assert.Equal(t, []string{"", lastModified.Format(http.TimeFormat)}, server.conditional)
+}
+
+func TestHttpClientReadDataDoesNotCacheWithoutValidator(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	cacheDir := filet.TmpDir(t, "")
+	server := &conditionalServer{content: "1"}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	client := HttpClient{CdnBaseUrl: httpServer.URL, Client: httpServer.Client(), Cache: &MetadataCache{Dir: cacheDir}}
+
+	//act
+	_, err := client.readData(context.Background(), "MyApp/beta/latest.txt")
+
+	//assert
+	assert.NoError(t, err)
+	files, _ := ioutil.ReadDir(cacheDir)
+	assert.Len(t, files, 0)
+}
@@ -1,20 +1,54 @@
 package updater
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"sort"
+	"strconv"
+	"strings"
 )
 
 const latestFileName = "latest.txt"
 
+// PrereleasePolicy
+// Decides which pre-release versions an Asset accepts as update.
+type PrereleasePolicy int
+
+const (
+	// PrereleaseNone only accepts regular releases.
+	PrereleaseNone PrereleasePolicy = iota
+	// PrereleaseRC accepts regular releases and release candidates like "2.0.0-rc.1".
+	PrereleaseRC
+	// PrereleaseAny accepts every pre-release like "2.0.0-alpha" or "2.0.0-beta.3".
+	PrereleaseAny
+)
+
+// SkippedUpdate
+// Describes a newer version found at the updates source which was not offered as update.
+type SkippedUpdate struct {
+	Version string
+	Reason  error
+}
+
+// ErrPrereleaseNotAllowed is reported as SkippedUpdate.Reason for pre-releases rejected by the PrereleasePolicy.
+var ErrPrereleaseNotAllowed = errors.New("pre-release not allowed by AllowPrerelease policy")
+
+// AvailableUpdate
+// An entry of the {Version}.json in a major directory of the updates source, one for every file published for the version.
 type AvailableUpdate struct {
-	Asset    string
-	Channel  string
-	Version  string
-	Specs    map[string]string
-	FilePath string
+	Asset    string            `json:"asset"`
+	Channel  string            `json:"channel"`
+	Version  string            `json:"version"`
+	Specs    map[string]string `json:"specs"`
+	FilePath string            `json:"filePath"`
+	// Size and the hex encoded Sha256 and Sha512 hashes of the file are optional. A download differing from them is
+	// aborted before its signature is verified.
+	Size   int64  `json:"size,omitempty"`
+	Sha256 string `json:"sha256,omitempty"`
+	Sha512 string `json:"sha512,omitempty"`
 }
 
 /*
@@ -31,18 +65,37 @@ is used to get current major´s minor or patch updater infos. A second call is u
 // CheckForUpdates
 // Looks for the latest updates available at the updates source. Returns information about the newest available major, minor and patch updates.
 func (a Asset) CheckForUpdates() (availableUpdates []UpdateInfo, updateFound bool, err error) {
-	currentMajor, _, _, err := getSemanticVersioningParts(a.AssetVersion)
+	return a.CheckForUpdatesContext(context.Background())
+}
+
+// CheckForUpdatesContext
+// Like CheckForUpdates, all requests to the updates source are canceled when ctx is done.
+func (a Asset) CheckForUpdatesContext(ctx context.Context) (availableUpdates []UpdateInfo, updateFound bool, err error) {
+	a.reportPhase(PhaseChecking)
+	if a.RollbackProtection && !a.RequireSignedMetadata {
+		return nil, false, ErrRollbackProtectionUnsigned
+	}
+	currentVersion, err := ParseVersion(a.AssetVersion)
 	if err != nil {
 		return nil, false, err
 	}
 
-	latestMajor, err := a.getLatestMajor()
+	if a.Repository != nil {
+		if err = a.refreshRepository(ctx); err != nil {
+			return nil, false, err
+		}
+	}
+
+	latestMajor, err := a.getLatestMajor(ctx)
 	if err != nil {
 		return nil, false, err
 	}
 
-	if latestMajor != currentMajor {
-		majorUpdate, majorUpdateFound, err := a.getUpdatesInFolder(latestMajor)
+	if latestMajor > currentVersion.Major {
+		majorUpdate, majorUpdateFound, err := a.getUpdatesInFolder(ctx, formatMajor(latestMajor))
+		if isVerificationFailure(err) {
+			return nil, false, err
+		}
 		if err != nil {
 			log.Println(err)
 		}
@@ -52,7 +105,10 @@ func (a Asset) CheckForUpdates() (availableUpdates []UpdateInfo, updateFound boo
 		}
 	}
 
-	patchOrMinorUpdate, patchOrMinorUpdateFound, err := a.getUpdatesInFolder(currentMajor)
+	patchOrMinorUpdate, patchOrMinorUpdateFound, err := a.getUpdatesInFolder(ctx, formatMajor(currentVersion.Major))
+	if isVerificationFailure(err) {
+		return nil, false, err
+	}
 	if err != nil {
 		log.Println(err)
 	}
@@ -63,8 +119,8 @@ func (a Asset) CheckForUpdates() (availableUpdates []UpdateInfo, updateFound boo
 	return availableUpdates, updateFound, nil
 }
 
-func (a Asset) getUpdatesInFolder(majorVersion string) (update *UpdateInfo, updateFound bool, err error) {
-	latest, err := a.getLatestVersionInMajorDir(majorVersion)
+func (a Asset) getUpdatesInFolder(ctx context.Context, majorVersion string) (update *UpdateInfo, updateFound bool, err error) {
+	latest, err := a.getLatestVersionInMajorDir(ctx, majorVersion)
 	if err != nil {
 		return nil, false, err
 	}
@@ -76,8 +132,12 @@ func (a Asset) getUpdatesInFolder(majorVersion string) (update *UpdateInfo, upda
 	if !updateIsNewerThanCurrent {
 		return nil, false, nil
 	}
+	if !a.AllowPrerelease.allows(latest) {
+		a.skipUpdate(latest, ErrPrereleaseNotAllowed)
+		return nil, false, nil
+	}
 
-	updatePath, err := a.getUpdatePathFromJson(majorVersion, latest)
+	availableUpdate, err := a.getUpdateFromJson(ctx, majorVersion, latest)
 	if err != nil {
 		return nil, false, err
 	}
@@ -88,62 +148,120 @@ func (a Asset) getUpdatesInFolder(majorVersion string) (update *UpdateInfo, upda
 
 	return &UpdateInfo{
 		Version: latest,
-		Path:    updatePath,
+		Path:    availableUpdate.FilePath,
 		Type:    updateType,
+		Size:    availableUpdate.Size,
+		Sha256:  availableUpdate.Sha256,
+		Sha512:  availableUpdate.Sha512,
 	}, true, nil
 }
 
-func (a Asset) getLatestMajor() (latestMajor string, err error) {
+func (p PrereleasePolicy) allows(version string) bool {
+	v, err := ParseVersion(version)
+	if err != nil {
+		return false
+	}
+	if !v.IsPrerelease() {
+		return true
+	}
+	switch p {
+	case PrereleaseAny:
+		return true
+	case PrereleaseRC:
+		return isReleaseCandidate(v.Prerelease[0])
+	}
+	return false
+}
+
+// isReleaseCandidate accepts the pre-release identifiers "rc" and "rc" followed by digits, like "rc" in "2.0.0-rc.1"
+// or "RC1" in "2.0.0-RC1".
+func isReleaseCandidate(identifier string) bool {
+	number := strings.ToLower(identifier)
+	if !strings.HasPrefix(number, "rc") {
+		return false
+	}
+	for _, c := range number[len("rc"):] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (a Asset) skipUpdate(version string, reason error) {
+	log.Println("skipped update of", a.AssetName, "to", version+":", reason)
+	if a.OnUpdateSkipped != nil {
+		a.OnUpdateSkipped(SkippedUpdate{Version: version, Reason: reason})
+	}
+}
+
+func (a Asset) getLatestMajor(ctx context.Context) (latestMajor uint64, err error) {
 	path := a.getPathToLatestMajor()
-	data, err := a.Client.readData(path)
+	data, err := a.readMetadata(ctx, path, "")
 	if err != nil {
-		return "", err
+		return 0, err
 	}
-	return string(data), nil
+	latestMajor, err = parseNumericIdentifier(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid major in %s: %v", path, err)
+	}
+	return latestMajor, nil
 }
 
-func (a Asset) getLatestVersionInMajorDir(major string) (version string, err error) {
+func (a Asset) getLatestVersionInMajorDir(ctx context.Context, major string) (version string, err error) {
 	path := a.getPathToLatestPatchInMajorDir(major)
-	data, err := a.Client.readData(path)
+	data, err := a.Client.readData(ctx, path)
 	if err != nil {
 		return "", err
 	}
-	return string(data), nil
+	// the signature of {Major}/latest.txt is bound to the version it points to
+	version = strings.TrimSpace(string(data))
+	if err = a.verifyMetadata(ctx, path, data, version); err != nil {
+		return "", err
+	}
+	return version, nil
+}
+
+func formatMajor(major uint64) string {
+	return strconv.FormatUint(major, 10)
 }
 
 func getUpdateType(currentVersion string, newVersion string) (updateType string, err error) {
-	cMajor, cMinor, _, err := getSemanticVersioningParts(currentVersion)
+	current, err := ParseVersion(currentVersion)
 	if err != nil {
 		return "", err
 	}
 
-	nMajor, nMinor, _, err := getSemanticVersioningParts(newVersion)
+	update, err := ParseVersion(newVersion)
 	if err != nil {
 		return "", err
 	}
 
-	if nMajor > cMajor {
+	if update.Major > current.Major {
 		return "major", nil
 	}
-	if nMinor > cMinor {
+	if update.Major == current.Major && update.Minor > current.Minor {
 		return "minor", nil
 	}
 	return "patch", nil
 }
 
-func (a Asset) getUpdatePathFromJson(majorVersion string, latestMinor string) (updatePath string, err error) {
+func (a Asset) getUpdateFromJson(ctx context.Context, majorVersion string, latestMinor string) (availableUpdate AvailableUpdate, err error) {
 	versionJsonPath := a.getPathToCdnVersionJson(majorVersion, latestMinor)
-	data, err := a.Client.readData(versionJsonPath)
+	data, err := a.readMetadata(ctx, versionJsonPath, latestMinor)
+	if err != nil {
+		return AvailableUpdate{}, err
+	}
 	var availableUpdates []AvailableUpdate
 	if err = json.Unmarshal(data, &availableUpdates); err != nil {
-		return "", err
+		return AvailableUpdate{}, err
 	}
 	for _, update := range availableUpdates {
 		if matches := a.isUpdateValid(update, latestMinor); matches {
-			return update.FilePath, nil
+			return update, nil
 		}
 	}
-	return updatePath, errors.New("no matching update in version json at update server")
+	return AvailableUpdate{}, errors.New("no matching update in version json at update server")
 }
 
 func (a Asset) isUpdateValid(availableUpdate AvailableUpdate, latest string) (match bool) {
@@ -159,7 +277,7 @@ func (a Asset) isUpdateValid(availableUpdate AvailableUpdate, latest string) (ma
 	if a.Channel != availableUpdate.Channel {
 		return false
 	}
-	if latest != availableUpdate.Version {
+	if !isSameVersion(latest, availableUpdate.Version) {
 		return false
 	}
 
@@ -179,3 +297,12 @@ func (a Asset) isUpdateValid(availableUpdate AvailableUpdate, latest string) (ma
 	}
 	return true
 }
+
+func isSameVersion(a string, b string) bool {
+	aVersion, aErr := ParseVersion(a)
+	bVersion, bErr := ParseVersion(b)
+	if aErr != nil || bErr != nil {
+		return a == b
+	}
+	return aVersion.Compare(bVersion) == 0
+}
@@ -1,8 +1,15 @@
 package updater
 
 import (
+	"context"
+	"github.com/Flaque/filet"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"testing/fstest"
 )
 
 func Test_getUpdateType(t *testing.T) {
@@ -52,6 +59,24 @@ func Test_getUpdateType(t *testing.T) {
 			wantUpdateType: "minor",
 			wantErr:        false,
 		},
+		{
+			name: "numeric major update",
+			args: args{
+				currentVersion: "9.3.1",
+				newVersion:     "10.0.0",
+			},
+			wantUpdateType: "major",
+			wantErr:        false,
+		},
+		{
+			name: "pre-release minor update",
+			args: args{
+				currentVersion: "v1.9.1",
+				newVersion:     "1.10.0-rc.1",
+			},
+			wantUpdateType: "minor",
+			wantErr:        false,
+		},
 		{
 			name: "patch update",
 			args: args{
@@ -287,3 +312,157 @@ func TestAsset_isUpdateValid(t *testing.T) {
 		})
 	}
 }
+
+// testUpdateFiles returns the files of an updates source publishing version of asset in the beta channel, the update
+// contains "Hello Gophers".
+func testUpdateFiles(asset string, version string) map[string]string {
+	major := strings.SplitN(version, ".", 2)[0]
+	majorDir := asset + "/beta/" + major + "/"
+	updateFile := majorDir + asset + "_" + version + ".txt"
+	return map[string]string{
+		asset + "/beta/latest.txt":   major,
+		majorDir + "latest.txt":      version,
+		majorDir + version + ".json": `[{"asset":"` + asset + `","channel":"beta","version":"` + version + `","specs":{},"filePath":"` + updateFile + `"}]`,
+		updateFile:                   "Hello Gophers",
+	}
+}
+
+// newTestCdn returns an updates source serving files from memory.
+func newTestCdn(files map[string]string) fstest.MapFS {
+	cdn := fstest.MapFS{}
+	for name, content := range files {
+		cdn[name] = &fstest.MapFile{Data: []byte(content)}
+	}
+	return cdn
+}
+
+func writeTestCdn(t *testing.T, files map[string]string) (cdnBaseUrl string) {
+	cdnBaseUrl = filet.TmpDir(t, "")
+	for name, content := range files {
+		path := filepath.Join(cdnBaseUrl, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return cdnBaseUrl
+}
+
+func TestAsset_CheckForUpdates(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	cdnBaseUrl := writeTestCdn(t, map[string]string{
+		"MyApp/beta/latest.txt":        "10\n",
+		"MyApp/beta/10/latest.txt":     "10.0.0\n",
+		"MyApp/beta/10/10.0.0.json":    `[{"asset":"MyApp","channel":"beta","version":"10.0.0","specs":{},"filePath":"MyApp/beta/10/MyApp_10.0.0.txt"}]`,
+		"MyApp/beta/9/latest.txt":      "9.1.0",
+		"MyApp/beta/9/9.1.0.json":      `[{"asset":"MyApp","channel":"beta","version":"9.1.0","specs":{},"filePath":"MyApp/beta/9/MyApp_9.1.0.txt","size":5,"sha256":"abc"}]`,
+		"MyApp/beta/9/MyApp_9.1.0.txt": "9.1.0",
+	})
+	asset := Asset{
+		AssetName:    "MyApp",
+		AssetVersion: "9.0.0",
+		Channel:      "beta",
+		Client:       LocalClient{CdnBaseUrl: cdnBaseUrl},
+	}
+
+	//act
+	got, updateFound, err := asset.CheckForUpdates()
+
+	//assert
+	assert.NoError(t, err)
+	assert.True(t, updateFound)
+	assert.Equal(t, []UpdateInfo{
+		{Version: "10.0.0", Path: "MyApp/beta/10/MyApp_10.0.0.txt", Type: "major"},
+		{Version: "9.1.0", Path: "MyApp/beta/9/MyApp_9.1.0.txt", Type: "minor", Size: 5, Sha256: "abc"},
+	}, got)
+}
+
+func TestPrereleasePolicy_allows(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  PrereleasePolicy
+		version string
+		want    bool
+	}{
+		{"none accepts release", PrereleaseNone, "2.0.0", true},
+		{"none rejects rc", PrereleaseNone, "2.0.0-rc.1", false},
+		{"rc accepts release", PrereleaseRC, "2.0.0", true},
+		{"rc accepts rc", PrereleaseRC, "2.0.0-rc.1", true},
+		{"rc accepts upper case RC", PrereleaseRC, "2.0.0-RC1", true},
+		{"rc rejects beta", PrereleaseRC, "2.0.0-beta.3", false},
+		{"rc rejects rcx", PrereleaseRC, "2.0.0-rcx", false},
+		{"rc rejects rcfoo", PrereleaseRC, "2.0.0-rcfoo.1", false},
+		{"rc rejects rc1a", PrereleaseRC, "2.0.0-rc1a", false},
+		{"any accepts beta", PrereleaseAny, "2.0.0-beta.3", true},
+		{"invalid version", PrereleaseAny, "2.0", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.allows(tt.version); got != tt.want {
+				t.Errorf("allows() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAsset_CheckForUpdatesSkipsPrerelease(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	cdnBaseUrl := writeTestCdn(t, map[string]string{
+		"MyApp/stable/latest.txt":          "2",
+		"MyApp/stable/2/latest.txt":        "2.0.0-beta.3",
+		"MyApp/stable/2/2.0.0-beta.3.json": `[{"asset":"MyApp","channel":"stable","version":"2.0.0-beta.3","specs":{},"filePath":"MyApp/stable/2/MyApp_2.0.0-beta.3.txt"}]`,
+		"MyApp/stable/1/latest.txt":        "1.0.0",
+	})
+	var skipped []SkippedUpdate
+	asset := Asset{
+		AssetName:    "MyApp",
+		AssetVersion: "1.0.0",
+		Channel:      "stable",
+		Client:       LocalClient{CdnBaseUrl: cdnBaseUrl},
+		OnUpdateSkipped: func(s SkippedUpdate) {
+			skipped = append(skipped, s)
+		},
+	}
+
+	//act
+	_, updateFound, err := asset.CheckForUpdates()
+
+	//assert
+	assert.NoError(t, err)
+	assert.False(t, updateFound)
+	assert.Equal(t, []SkippedUpdate{{Version: "2.0.0-beta.3", Reason: ErrPrereleaseNotAllowed}}, skipped)
+
+	//act
+	asset.AllowPrerelease = PrereleaseAny
+	got, updateFound, err := asset.CheckForUpdates()
+
+	//assert
+	assert.NoError(t, err)
+	assert.True(t, updateFound)
+	assert.Equal(t, "2.0.0-beta.3", got[0].Version)
+}
+
+func TestAsset_CheckForUpdatesContextCanceled(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	cdnBaseUrl := writeTestCdn(t, map[string]string{"MyApp/beta/latest.txt": "1"})
+	asset := Asset{
+		AssetName:    "MyApp",
+		AssetVersion: "1.0.0",
+		Channel:      "beta",
+		Client:       LocalClient{CdnBaseUrl: cdnBaseUrl},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	//act
+	_, updateFound, err := asset.CheckForUpdatesContext(ctx)
+
+	//assert
+	assert.False(t, updateFound)
+	assert.Equal(t, context.Canceled, err)
+}
@@ -0,0 +1,356 @@
+package updater
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	partialDownloadSuffix = ".part"
+	downloadStateSuffix   = ".json"
+)
+
+var (
+	// ErrSizeMismatch is returned if a download is larger or smaller than the size pinned in its version json.
+	ErrSizeMismatch = errors.New("size differs from version json")
+	// ErrHashMismatch is returned if the hash of a download differs from the hash pinned in its version json.
+	ErrHashMismatch = errors.New("hash differs from version json")
+)
+
+// rangeClient is implemented by clients which can continue an interrupted download.
+type rangeClient interface {
+	// openDataRange opens location starting at offset, if the remote file still matches validator (an ETag or a
+	// Last-Modified date). Clients fall back to the whole file, which is reported by remoteFileInfo.Resumed.
+	openDataRange(ctx context.Context, location string, offset int64, validator string) (data io.ReadCloser, info remoteFileInfo, err error)
+}
+
+type remoteFileInfo struct {
+	Resumed      bool
+	Size         int64
+	ETag         string
+	LastModified string
+}
+
+// downloadState is stored next to a partial download and used to resume it.
+type downloadState struct {
+	Location     string
+	Size         int64
+	ETag         string
+	LastModified string
+}
+
+// validator returns the value to send as If-Range. Weak ETags can not be used for range requests.
+func (d downloadState) validator() string {
+	if d.ETag != "" && !strings.HasPrefix(d.ETag, "W/") {
+		return d.ETag
+	}
+	return d.LastModified
+}
+
+// pinnedFile holds the size and hashes a download is expected to have. Empty fields are not checked.
+type pinnedFile struct {
+	Size   int64
+	Sha256 string
+	Sha512 string
+}
+
+func (u UpdateInfo) getPinnedFile() pinnedFile {
+	return pinnedFile{Size: u.Size, Sha256: u.Sha256, Sha512: u.Sha512}
+}
+
+// saveRemoteFile streams src into a partial file next to dest, which is renamed to dest once the download is complete.
+// Updates are never held in memory as a whole. If the client supports ranges, an interrupted download is kept together
+// with a state file and continued by the next call. The download is checked against pin while it is streamed and
+// removed if it differs.
+func (a Asset) saveRemoteFile(ctx context.Context, src string, dest string, pin pinnedFile) (err error) {
+	partFile := dest + partialDownloadSuffix
+	stateFile := partFile + downloadStateSuffix
+
+	resumable, ok := a.Client.(rangeClient)
+	if !ok {
+		_ = os.Remove(stateFile)
+		remote, err := a.Client.openData(ctx, src)
+		if err != nil {
+			return err
+		}
+		defer remote.Close()
+		size := getSize(remote)
+		if err = pin.checkSize(size); err != nil {
+			return fmt.Errorf("%s: %w", src, err)
+		}
+		pinned, err := newPinnedReader(withContext(ctx, remote), pin, partFile, 0)
+		if err != nil {
+			return err
+		}
+		if err = writePartialFile(partFile, a.trackDownload(pinned, 0, size), false); err != nil {
+			_ = os.Remove(partFile)
+			return wrapPinError(src, err)
+		}
+		return os.Rename(partFile, dest)
+	}
+
+	offset, validator := getResumeOffset(partFile, stateFile, src)
+	remote, info, err := resumable.openDataRange(ctx, src, offset, validator)
+	if err != nil {
+		return err
+	}
+	defer remote.Close()
+	if err = pin.checkSize(info.Size); err != nil {
+		_ = os.Remove(partFile)
+		_ = os.Remove(stateFile)
+		return fmt.Errorf("%s: %w", src, err)
+	}
+
+	state := downloadState{Location: src, Size: info.Size, ETag: info.ETag, LastModified: info.LastModified}
+	if err = writeDownloadState(stateFile, state); err != nil {
+		return err
+	}
+	if !info.Resumed {
+		offset = 0
+	}
+	pinned, err := newPinnedReader(remote, pin, partFile, offset)
+	if err != nil {
+		return err
+	}
+	if err = writePartialFile(partFile, a.trackDownload(pinned, offset, info.Size), info.Resumed); err != nil {
+		// a download differing from its pin is not resumed, its bytes already received may be the wrong ones
+		if state.validator() == "" || errors.Is(err, ErrSizeMismatch) || errors.Is(err, ErrHashMismatch) {
+			_ = os.Remove(partFile)
+			_ = os.Remove(stateFile)
+		}
+		return wrapPinError(src, err)
+	}
+	if err = os.Rename(partFile, dest); err != nil {
+		return err
+	}
+	return os.Remove(stateFile)
+}
+
+// getResumeOffset returns where a previous download of location stopped. Downloads without state file, of another
+// location or without validator are started over.
+func getResumeOffset(partFile string, stateFile string, location string) (offset int64, validator string) {
+	info, err := os.Stat(partFile)
+	if err != nil || info.Size() == 0 {
+		return 0, ""
+	}
+	data, err := ioutil.ReadFile(stateFile)
+	if err != nil {
+		return 0, ""
+	}
+	var state downloadState
+	if err = json.Unmarshal(data, &state); err != nil {
+		return 0, ""
+	}
+	if state.Location != location || state.validator() == "" {
+		return 0, ""
+	}
+	if state.Size >= 0 && info.Size() > state.Size {
+		return 0, ""
+	}
+	return info.Size(), state.validator()
+}
+
+// checkSize fails early if the size announced by the updates source differs from the pinned size. size is -1 if unknown.
+func (p pinnedFile) checkSize(size int64) error {
+	if p.Size > 0 && size >= 0 && size != p.Size {
+		return fmt.Errorf("%w: %d bytes instead of %d", ErrSizeMismatch, size, p.Size)
+	}
+	return nil
+}
+
+// pinnedReader checks the bytes read against a pinnedFile. Reading fails as soon as more bytes than pinned are read,
+// the size and hashes are compared at the end of the file.
+type pinnedReader struct {
+	reader io.Reader
+	pin    pinnedFile
+	read   int64
+	sha256 hash.Hash
+	sha512 hash.Hash
+}
+
+// newPinnedReader checks r, which continues a download at offset. The first offset bytes are hashed from partFile.
+func newPinnedReader(r io.Reader, pin pinnedFile, partFile string, offset int64) (io.Reader, error) {
+	if pin == (pinnedFile{}) {
+		return r, nil
+	}
+	p := &pinnedReader{reader: r, pin: pin, sha256: sha256.New(), sha512: sha512.New()}
+	if offset > 0 {
+		file, err := os.Open(partFile)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		if _, err = io.CopyN(io.MultiWriter(p.sha256, p.sha512), file, offset); err != nil {
+			return nil, err
+		}
+		p.read = offset
+	}
+	return p, nil
+}
+
+func (p *pinnedReader) Read(b []byte) (n int, err error) {
+	n, err = p.reader.Read(b)
+	p.read += int64(n)
+	_, _ = p.sha256.Write(b[:n])
+	_, _ = p.sha512.Write(b[:n])
+	if p.pin.Size > 0 && p.read > p.pin.Size {
+		return n, fmt.Errorf("%w: more than %d bytes", ErrSizeMismatch, p.pin.Size)
+	}
+	if err == io.EOF {
+		if verifyErr := p.verify(); verifyErr != nil {
+			return n, verifyErr
+		}
+	}
+	return n, err
+}
+
+func (p *pinnedReader) verify() error {
+	if p.pin.Size > 0 && p.read != p.pin.Size {
+		return fmt.Errorf("%w: %d bytes instead of %d", ErrSizeMismatch, p.read, p.pin.Size)
+	}
+	if p.pin.Sha256 != "" && !strings.EqualFold(p.pin.Sha256, hex.EncodeToString(p.sha256.Sum(nil))) {
+		return fmt.Errorf("%w: sha256", ErrHashMismatch)
+	}
+	if p.pin.Sha512 != "" && !strings.EqualFold(p.pin.Sha512, hex.EncodeToString(p.sha512.Sum(nil))) {
+		return fmt.Errorf("%w: sha512", ErrHashMismatch)
+	}
+	return nil
+}
+
+// wrapPinError adds the location to errors of the pinnedReader.
+func wrapPinError(location string, err error) error {
+	if errors.Is(err, ErrSizeMismatch) || errors.Is(err, ErrHashMismatch) {
+		return fmt.Errorf("%s: %w", location, err)
+	}
+	return err
+}
+
+// withContext stops reading from r once ctx is done. Used for clients which do not handle the context themselves.
+func withContext(ctx context.Context, r io.Reader) io.Reader {
+	return &contextReader{ctx: ctx, reader: r}
+}
+
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (n int, err error) {
+	if err = c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.reader.Read(p)
+}
+
+// getSize returns the size of local files or -1.
+func getSize(remote io.Reader) int64 {
+	if file, ok := remote.(interface{ Stat() (os.FileInfo, error) }); ok {
+		if info, err := file.Stat(); err == nil {
+			return info.Size()
+		}
+	}
+	return -1
+}
+
+func writeDownloadState(stateFile string, state downloadState) error {
+	content, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(stateFile, content, 0644)
+}
+
+func writePartialFile(partFile string, remote io.Reader, appendToFile bool) (err error) {
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appendToFile {
+		flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	file, err := os.OpenFile(partFile, flag, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(file, remote); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (h HttpClient) openDataRange(ctx context.Context, location string, offset int64, validator string) (io.ReadCloser, remoteFileInfo, error) {
+	location, err := getTargetUrl(h.CdnBaseUrl, location)
+	if err != nil {
+		return nil, remoteFileInfo{}, err
+	}
+	return openHttpRangeRequest(ctx, location, offset, validator, h.httpClient(timeoutClient{timeout: h.getDownloadIdleTimeout(), idle: true}))
+}
+
+func openHttpRangeRequest(ctx context.Context, location string, offset int64, validator string, client httpClientInterface) (io.ReadCloser, remoteFileInfo, error) {
+	header := http.Header{}
+	if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		header.Set("If-Range", validator)
+	}
+	resp, err := sendHttpGetRequest(ctx, location, header, client, http.StatusRequestedRangeNotSatisfiable)
+	if err != nil {
+		return nil, remoteFileInfo{}, err
+	}
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		if offset > 0 {
+			_ = resp.Body.Close()
+			return openHttpRangeRequest(ctx, location, 0, "", client)
+		}
+		err = checkHttpResponse(location, resp)
+		_ = resp.Body.Close()
+		return nil, remoteFileInfo{}, err
+	}
+
+	info := remoteFileInfo{
+		Size:         resp.ContentLength,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	if resp.StatusCode == http.StatusPartialContent {
+		start, size, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil || start != offset {
+			_ = resp.Body.Close()
+			return nil, remoteFileInfo{}, fmt.Errorf("unexpected Content-Range %q for %s", resp.Header.Get("Content-Range"), location)
+		}
+		info.Resumed = true
+		info.Size = size
+	}
+	return resp.Body, info, nil
+}
+
+// parseContentRange parses a header like "bytes 100-199/200". The size is -1 if unknown.
+func parseContentRange(contentRange string) (start int64, size int64, err error) {
+	var end int64
+	var total string
+	if _, err = fmt.Sscanf(contentRange, "bytes %d-%d/%s", &start, &end, &total); err != nil {
+		return 0, 0, err
+	}
+	if end < start {
+		return 0, 0, errors.New("invalid Content-Range " + contentRange)
+	}
+	if total == "*" {
+		return start, -1, nil
+	}
+	if _, err = fmt.Sscanf(total, "%d", &size); err != nil {
+		return 0, 0, err
+	}
+	return start, size, nil
+}
@@ -0,0 +1,308 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"github.com/Flaque/filet"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAsset_saveRemoteFileStreamsFromHttp(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	targetFolder := filet.TmpDir(t, "")
+	content := strings.Repeat("0123456789", 100000)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/MyApp/beta/1/MyApp_1.0.1.exe" {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = io.Copy(rw, strings.NewReader(content))
+	}))
+	defer server.Close()
+	asset := Asset{
+		AssetName:    "MyApp",
+		Client:       HttpClient{CdnBaseUrl: server.URL, Client: server.Client()},
+		TargetFolder: targetFolder,
+	}
+	dest := asset.getPathToImportedUpdateFile("MyApp/beta/1/MyApp_1.0.1.exe")
+
+	//act
+	err := asset.saveRemoteFile(context.Background(), "MyApp/beta/1/MyApp_1.0.1.exe", dest, pinnedFile{})
+
+	//assert
+	assert.NoError(t, err)
+	got, _ := ioutil.ReadFile(dest)
+	assert.Equal(t, content, string(got))
+	files, _ := ioutil.ReadDir(targetFolder)
+	assert.Len(t, files, 1)
+
+	//act
+	err = asset.saveRemoteFile(context.Background(), "MyApp/beta/1/missing.exe", filepath.Join(targetFolder, "update_missing.exe"), pinnedFile{})
+
+	//assert
+	assert.Error(t, err)
+	files, _ = ioutil.ReadDir(targetFolder)
+	assert.Len(t, files, 1)
+}
+
+func TestAsset_saveRemoteFileRemovesPartialDownload(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	targetFolder := filet.TmpDir(t, "")
+	client := fakeHttpClient{resp: &http.Response{
+		StatusCode:    http.StatusOK,
+		ContentLength: 100,
+		Body:          &fakeBody{Reader: strings.NewReader("only a part")},
+	}}
+	body, err := openHttpGetRequest(context.Background(), "https://example.org/MyApp/beta/1/MyApp_1.0.1.exe", client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asset := Asset{Client: staticClient{body: body}, TargetFolder: targetFolder}
+
+	//act
+	err = asset.saveRemoteFile(context.Background(), "MyApp/beta/1/MyApp_1.0.1.exe", filepath.Join(targetFolder, "update_MyApp_1.0.1.exe"), pinnedFile{})
+
+	//assert
+	assert.True(t, errors.Is(err, ErrContentLengthMismatch))
+	files, _ := ioutil.ReadDir(targetFolder)
+	assert.Len(t, files, 0)
+}
+
+type rangeServer struct {
+	content      string
+	etag         string
+	ignoreRanges bool
+	interruptAt  int
+	rangeHeaders []string
+}
+
+func (r *rangeServer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	r.rangeHeaders = append(r.rangeHeaders, req.Header.Get("Range"))
+	if r.interruptAt > 0 {
+		interruptAt := r.interruptAt
+		r.interruptAt = 0
+		rw.Header().Set("ETag", r.etag)
+		rw.Header().Set("Content-Length", "10000000")
+		_, _ = rw.Write([]byte(r.content[:interruptAt]))
+		rw.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	if r.ignoreRanges {
+		rw.Header().Set("ETag", r.etag)
+		_, _ = rw.Write([]byte(r.content))
+		return
+	}
+	rw.Header().Set("ETag", r.etag)
+	http.ServeContent(rw, req, "", time.Time{}, strings.NewReader(r.content))
+}
+
+func newRangeTestAsset(t *testing.T, server *rangeServer) (asset Asset, dest string, closeServer func()) {
+	httpServer := httptest.NewServer(server)
+	asset = Asset{
+		AssetName:    "MyApp",
+		Client:       HttpClient{CdnBaseUrl: httpServer.URL, Client: httpServer.Client()},
+		TargetFolder: filet.TmpDir(t, ""),
+	}
+	return asset, asset.getPathToImportedUpdateFile("MyApp/beta/1/MyApp_1.0.1.exe"), httpServer.Close
+}
+
+func TestAsset_saveRemoteFileResumesInterruptedDownload(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	server := &rangeServer{content: strings.Repeat("0123456789", 1000), etag: `"v1"`, interruptAt: 4000}
+	asset, dest, closeServer := newRangeTestAsset(t, server)
+	defer closeServer()
+
+	//act
+	err := asset.saveRemoteFile(context.Background(), "MyApp/beta/1/MyApp_1.0.1.exe", dest, pinnedFile{})
+
+	//assert
+	assert.Error(t, err)
+	part, _ := ioutil.ReadFile(dest + partialDownloadSuffix)
+	assert.Equal(t, server.content[:4000], string(part))
+	assert.FileExists(t, dest+partialDownloadSuffix+downloadStateSuffix)
+
+	//act
+	err = asset.saveRemoteFile(context.Background(), "MyApp/beta/1/MyApp_1.0.1.exe", dest, pinnedFile{})
+
+	//assert
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"", "bytes=4000-"}, server.rangeHeaders)
+	got, _ := ioutil.ReadFile(dest)
+	assert.Equal(t, server.content, string(got))
+	files, _ := ioutil.ReadDir(asset.TargetFolder)
+	assert.Len(t, files, 1)
+}
+
+func TestAsset_saveRemoteFileRestartsChangedDownload(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	server := &rangeServer{content: strings.Repeat("0123456789", 1000), etag: `"v1"`, interruptAt: 4000}
+	asset, dest, closeServer := newRangeTestAsset(t, server)
+	defer closeServer()
+	_ = asset.saveRemoteFile(context.Background(), "MyApp/beta/1/MyApp_1.0.1.exe", dest, pinnedFile{})
+	server.content = strings.Repeat("abcdefghij", 1000)
+	server.etag = `"v2"`
+
+	//act
+	err := asset.saveRemoteFile(context.Background(), "MyApp/beta/1/MyApp_1.0.1.exe", dest, pinnedFile{})
+
+	//assert
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"", "bytes=4000-"}, server.rangeHeaders)
+	got, _ := ioutil.ReadFile(dest)
+	assert.Equal(t, server.content, string(got))
+}
+
+func TestAsset_saveRemoteFileServerIgnoresRanges(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	server := &rangeServer{content: strings.Repeat("0123456789", 1000), etag: `"v1"`, interruptAt: 4000}
+	asset, dest, closeServer := newRangeTestAsset(t, server)
+	defer closeServer()
+	_ = asset.saveRemoteFile(context.Background(), "MyApp/beta/1/MyApp_1.0.1.exe", dest, pinnedFile{})
+	server.ignoreRanges = true
+
+	//act
+	err := asset.saveRemoteFile(context.Background(), "MyApp/beta/1/MyApp_1.0.1.exe", dest, pinnedFile{})
+
+	//assert
+	assert.NoError(t, err)
+	got, _ := ioutil.ReadFile(dest)
+	assert.Equal(t, server.content, string(got))
+}
+
+func Test_parseContentRange(t *testing.T) {
+	tests := []struct {
+		name         string
+		contentRange string
+		wantStart    int64
+		wantSize     int64
+		wantErr      bool
+	}{
+		{"known size", "bytes 100-199/200", 100, 200, false},
+		{"unknown size", "bytes 100-199/*", 100, -1, false},
+		{"end before start", "bytes 100-99/200", 0, 0, true},
+		{"invalid", "items 1-2/3", 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStart, gotSize, err := parseContentRange(tt.contentRange)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseContentRange() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			assert.Equal(t, tt.wantStart, gotStart)
+			assert.Equal(t, tt.wantSize, gotSize)
+		})
+	}
+}
+
+func newTestPin(content string) pinnedFile {
+	target, _ := NewTargetFile(strings.NewReader(content))
+	return pinnedFile{Size: target.Length, Sha256: target.Hashes["sha256"], Sha512: target.Hashes["sha512"]}
+}
+
+func TestAsset_saveRemoteFilePinned(t *testing.T) {
+	defer filet.CleanUp(t)
+	content := strings.Repeat("0123456789", 1000)
+	tamperedSha512 := newTestPin(content)
+	tamperedSha512.Sha512 = newTestPin("tampered").Sha512
+	tests := []struct {
+		name      string
+		pin       pinnedFile
+		wantErrIs error
+	}{
+		{"matching pin", newTestPin(content), nil},
+		{"matching size", pinnedFile{Size: int64(len(content))}, nil},
+		{"other sha256", pinnedFile{Sha256: newTestPin("tampered").Sha256}, ErrHashMismatch},
+		{"other sha512", tamperedSha512, ErrHashMismatch},
+		{"larger than pinned", pinnedFile{Size: 5000}, ErrSizeMismatch},
+		{"smaller than pinned", pinnedFile{Size: 20000}, ErrSizeMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			//arrange
+			// without ranges the response is chunked, so its size is only known at its end
+			server := &rangeServer{content: content, etag: `"v1"`, ignoreRanges: true}
+			asset, dest, closeServer := newRangeTestAsset(t, server)
+			defer closeServer()
+
+			//act
+			err := asset.saveRemoteFile(context.Background(), "MyApp/beta/1/MyApp_1.0.1.exe", dest, tt.pin)
+
+			//assert
+			files, _ := ioutil.ReadDir(asset.TargetFolder)
+			if tt.wantErrIs != nil {
+				assert.True(t, errors.Is(err, tt.wantErrIs), "got %v", err)
+				assert.Len(t, files, 0)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Len(t, files, 1)
+		})
+	}
+}
+
+func TestAsset_saveRemoteFilePinnedLocalFile(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	cdnBaseUrl := writeTestCdn(t, map[string]string{"MyApp/beta/1/MyApp_1.0.1.exe": "tampered and larger"})
+	asset := Asset{AssetName: "MyApp", Client: LocalClient{CdnBaseUrl: cdnBaseUrl}, TargetFolder: filet.TmpDir(t, "")}
+
+	//act
+	err := asset.saveRemoteFile(context.Background(), "MyApp/beta/1/MyApp_1.0.1.exe", asset.getPathToImportedUpdateFile("MyApp/beta/1/MyApp_1.0.1.exe"), newTestPin("update"))
+
+	//assert
+	assert.True(t, errors.Is(err, ErrSizeMismatch), "got %v", err)
+	files, _ := ioutil.ReadDir(asset.TargetFolder)
+	assert.Len(t, files, 0)
+}
+
+func TestAsset_saveRemoteFilePinnedResumedDownload(t *testing.T) {
+	defer filet.CleanUp(t)
+	content := strings.Repeat("0123456789", 1000)
+	tests := []struct {
+		name      string
+		part      string
+		wantErrIs error
+	}{
+		{"received bytes match", content[:4000], nil},
+		{"received bytes differ", strings.Repeat("x", 4000), ErrHashMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			//arrange
+			server := &rangeServer{content: content, etag: `"v1"`}
+			asset, dest, closeServer := newRangeTestAsset(t, server)
+			defer closeServer()
+			_ = ioutil.WriteFile(dest+partialDownloadSuffix, []byte(tt.part), 0644)
+			_ = writeDownloadState(dest+partialDownloadSuffix+downloadStateSuffix, downloadState{Location: "MyApp/beta/1/MyApp_1.0.1.exe", Size: int64(len(content)), ETag: `"v1"`})
+
+			//act
+			err := asset.saveRemoteFile(context.Background(), "MyApp/beta/1/MyApp_1.0.1.exe", dest, newTestPin(content))
+
+			//assert
+			assert.Equal(t, []string{"bytes=4000-"}, server.rangeHeaders)
+			files, _ := ioutil.ReadDir(asset.TargetFolder)
+			if tt.wantErrIs != nil {
+				assert.True(t, errors.Is(err, tt.wantErrIs), "got %v", err)
+				assert.Len(t, files, 0)
+				return
+			}
+			assert.NoError(t, err)
+			got, _ := ioutil.ReadFile(dest)
+			assert.Equal(t, content, string(got))
+		})
+	}
+}
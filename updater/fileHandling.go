@@ -1,12 +1,17 @@
 package updater
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/artdarek/go-unzip"
+	"io"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"net/url"
+	"os"
 	"path"
 	"path/filepath"
 	"time"
@@ -16,32 +21,209 @@ type httpClientInterface interface {
 	Do(req *http.Request) (*http.Response, error)
 }
 
-var (
-	httpImplementation httpClientInterface
-)
+// defaultHttpTimeout is used if HttpClient.Timeout is not set.
+const defaultHttpTimeout = 10 * time.Second
+
+// defaultDownloadIdleTimeout is used if HttpClient.DownloadIdleTimeout is not set.
+const defaultDownloadIdleTimeout = time.Minute
+
+const maxHttpErrorBodyLength = 512
+
+// ErrContentLengthMismatch is returned if a response body is shorter or longer than its Content-Length header.
+var ErrContentLengthMismatch = errors.New("content length mismatch")
 
-func init() {
-	httpImplementation = &http.Client{Timeout: 10 * time.Second}
+// HttpError
+// Returned by HttpClient if the updates source answers with a non 2xx status code.
+// Body holds the beginning of the response body, truncated to 512 bytes ending with "..." for longer bodies.
+type HttpError struct {
+	StatusCode int
+	Url        string
+	Body       string
+}
+
+func (e *HttpError) Error() string {
+	return fmt.Sprintf("GET %s: %d %s", e.Url, e.StatusCode, http.StatusText(e.StatusCode))
 }
 
 type Client interface {
-	readData(location string) (data []byte, err error)
+	readData(ctx context.Context, location string) (data []byte, err error)
+	openData(ctx context.Context, location string) (data io.ReadCloser, err error)
 }
 
 type HttpClient struct {
 	CdnBaseUrl string
+	// Timeout limits each attempt of a request for metadata like latest.txt, version jsons and signatures, including
+	// reading the response. Defaults to 10 seconds.
+	Timeout time.Duration
+	// DownloadIdleTimeout aborts downloads of updates if no data arrives for this long, including the wait for the
+	// response headers. The download as a whole is only limited by the context. Defaults to 1 minute.
+	DownloadIdleTimeout time.Duration
+	// Client sends all requests. If nil, a client using Transport is created for every request.
+	Client *http.Client
+	// Transport is used if Client is nil, e.g. to configure proxies, custom CA roots or client certificates.
+	// Defaults to http.DefaultTransport.
+	Transport http.RoundTripper
+	// Header is added to every request, e.g. a User-Agent or an API key.
+	Header http.Header
+	// HeaderProvider is called for every request to set headers which change over time, like bearer tokens.
+	HeaderProvider func(req *http.Request) error
+	// Retry retries requests failing with network errors or retryable status codes. Nil disables retries.
+	Retry *RetryPolicy
+	// Cache stores metadata on disk and revalidates it with conditional requests. Nil disables caching.
+	Cache *MetadataCache
 }
 
 type LocalClient struct {
 	CdnBaseUrl string
 }
 
-func (h HttpClient) readData(location string) ([]byte, error) {
+func (h HttpClient) readData(ctx context.Context, location string) ([]byte, error) {
 	location, err := getTargetUrl(h.CdnBaseUrl, location)
 	if err != nil {
 		return nil, err
 	}
-	return readHttpGetRequest(location, httpImplementation)
+	client := h.httpClient(timeoutClient{timeout: h.getTimeout()})
+	if h.Cache != nil {
+		return readCachedHttpGetRequest(ctx, location, client, *h.Cache)
+	}
+	return readHttpGetRequest(ctx, location, client)
+}
+
+// httpClient returns the configured client, which adds Header and the headers of HeaderProvider to every request,
+// limits every attempt like timeout and retries failed attempts according to the RetryPolicy.
+func (h HttpClient) httpClient(timeout timeoutClient) httpClientInterface {
+	client := h.Client
+	if client == nil {
+		client = &http.Client{Transport: h.Transport}
+	}
+	var configured httpClientInterface = headerClient{
+		client:         client,
+		header:         h.Header,
+		headerProvider: h.HeaderProvider,
+	}
+	timeout.next = configured
+	configured = timeout
+	if h.Retry != nil {
+		configured = retryClient{next: configured, policy: *h.Retry}
+	}
+	return configured
+}
+
+type headerClient struct {
+	client         *http.Client
+	header         http.Header
+	headerProvider func(req *http.Request) error
+}
+
+func (c headerClient) Do(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for key, values := range c.header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	if c.headerProvider != nil {
+		if err := c.headerProvider(req); err != nil {
+			return nil, &headerProviderError{err: err}
+		}
+	}
+	return c.client.Do(req)
+}
+
+// headerProviderError is returned if HttpClient.HeaderProvider fails, it is not retried.
+type headerProviderError struct {
+	err error
+}
+
+func (e *headerProviderError) Error() string {
+	return "header provider: " + e.err.Error()
+}
+
+func (e *headerProviderError) Unwrap() error {
+	return e.err
+}
+
+func (h HttpClient) getTimeout() time.Duration {
+	if h.Timeout > 0 {
+		return h.Timeout
+	}
+	return defaultHttpTimeout
+}
+
+func (h HttpClient) getDownloadIdleTimeout() time.Duration {
+	if h.DownloadIdleTimeout > 0 {
+		return h.DownloadIdleTimeout
+	}
+	return defaultDownloadIdleTimeout
+}
+
+// timeoutClient limits every attempt of a request to timeout, including reading its body. With idle set the timeout
+// starts again whenever data of the body arrives, so a stalled server can not block a download forever.
+type timeoutClient struct {
+	next    httpClientInterface
+	timeout time.Duration
+	idle    bool
+}
+
+func (c timeoutClient) Do(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	body := &timeoutBody{parent: req.Context(), ctx: ctx, cancel: cancel, timeout: c.timeout, idle: c.idle, location: req.URL.String()}
+	body.timer = time.AfterFunc(c.timeout, cancel)
+	resp, err := c.next.Do(req.WithContext(ctx))
+	if err != nil {
+		body.timer.Stop()
+		err = body.wrapError(err)
+		cancel()
+		return nil, err
+	}
+	if c.idle {
+		body.timer.Stop()
+	}
+	body.ReadCloser = resp.Body
+	resp.Body = body
+	return resp, nil
+}
+
+type timeoutBody struct {
+	io.ReadCloser
+	parent   context.Context
+	ctx      context.Context
+	cancel   context.CancelFunc
+	timer    *time.Timer
+	timeout  time.Duration
+	idle     bool
+	location string
+}
+
+func (b *timeoutBody) Read(p []byte) (n int, err error) {
+	if b.idle {
+		b.timer.Reset(b.timeout)
+	}
+	n, err = b.ReadCloser.Read(p)
+	if b.idle {
+		b.timer.Stop()
+	}
+	if err != nil && err != io.EOF {
+		err = b.wrapError(err)
+	}
+	return n, err
+}
+
+func (b *timeoutBody) Close() error {
+	b.timer.Stop()
+	b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// wrapError reports requests canceled by the timer, not by the caller, as context.DeadlineExceeded.
+func (b *timeoutBody) wrapError(err error) error {
+	if b.ctx.Err() == nil || b.parent.Err() != nil {
+		return err
+	}
+	if b.idle {
+		return fmt.Errorf("GET %s: no data received for %v: %w", b.location, b.timeout, context.DeadlineExceeded)
+	}
+	return fmt.Errorf("GET %s: no response within %v: %w", b.location, b.timeout, context.DeadlineExceeded)
 }
 
 func getTargetUrl(cdnBaseUrl string, location string) (Url string, err error) {
@@ -54,29 +236,172 @@ func getTargetUrl(cdnBaseUrl string, location string) (Url string, err error) {
 	return u.String(), nil
 }
 
-func readHttpGetRequest(location string, client httpClientInterface) ([]byte, error) {
-	req, err := http.NewRequest("GET", location, nil)
+func readHttpGetRequest(ctx context.Context, location string, client httpClientInterface) (data []byte, err error) {
+	body, err := openHttpGetRequest(ctx, location, client)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := body.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	data, err = ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// readCachedHttpGetRequest sends a conditional request if location is cached and returns the cached data if it was
+// not modified. Responses with ETag or Last-Modified header are cached.
+func readCachedHttpGetRequest(ctx context.Context, location string, client httpClientInterface, cache MetadataCache) (data []byte, err error) {
+	header := http.Header{}
+	entry, cached := cache.get(location)
+	if cached {
+		if entry.ETag != "" {
+			header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
+	resp, err := sendHttpGetRequest(ctx, location, header, client, http.StatusNotModified)
 	if err != nil {
 		return nil, err
 	}
+	defer func() {
+		if closeErr := resp.Body.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	if resp.StatusCode == http.StatusNotModified {
+		if !cached {
+			return nil, checkHttpResponse(location, resp)
+		}
+		return entry.Body, nil
+	}
+	data, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	entry = cacheEntry{
+		Url:          location,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Body:         data,
+	}
+	if entry.ETag == "" && entry.LastModified == "" {
+		cache.remove(location)
+		return data, nil
+	}
+	if err = cache.put(entry); err != nil {
+		log.Println("could not cache", location, err)
+	}
+	return data, nil
+}
+
+// openHttpGetRequest returns the body of a successful GET request. Reading the body fails with ErrContentLengthMismatch
+// if it does not match the announced Content-Length.
+func openHttpGetRequest(ctx context.Context, location string, client httpClientInterface) (body io.ReadCloser, err error) {
+	resp, err := sendHttpGetRequest(ctx, location, nil, client)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// sendHttpGetRequest sends a GET request with the additional header. Responses with other status codes than 2xx and
+// accepted are closed and turned into an HttpError. Reading the body of the response fails with
+// ErrContentLengthMismatch if it does not match the announced Content-Length.
+func sendHttpGetRequest(ctx context.Context, location string, header http.Header, client httpClientInterface, accepted ...int) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", location, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
-	return ioutil.ReadAll(resp.Body)
+	if !containsStatusCode(accepted, resp.StatusCode) {
+		if err = checkHttpResponse(location, resp); err != nil {
+			_ = resp.Body.Close()
+			return nil, err
+		}
+	}
+	resp.Body = &lengthCheckingReader{
+		ReadCloser: resp.Body,
+		location:   location,
+		expected:   resp.ContentLength,
+	}
+	return resp, nil
 }
 
-func (l LocalClient) readData(location string) (data []byte, err error) {
-	return ioutil.ReadFile(filepath.Join(l.CdnBaseUrl, location))
+func containsStatusCode(statusCodes []int, statusCode int) bool {
+	for _, s := range statusCodes {
+		if s == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+type lengthCheckingReader struct {
+	io.ReadCloser
+	location string
+	expected int64
+	read     int64
+}
+
+func (l *lengthCheckingReader) Read(p []byte) (n int, err error) {
+	n, err = l.ReadCloser.Read(p)
+	l.read += int64(n)
+	if l.expected >= 0 && (l.read > l.expected || err == io.EOF && l.read != l.expected) {
+		return n, fmt.Errorf("%w: %s announced %d bytes, got %d", ErrContentLengthMismatch, l.location, l.expected, l.read)
+	}
+	return n, err
+}
+
+// checkHttpResponse turns every non 2xx response into an HttpError, so error pages are never taken for update files.
+func checkHttpResponse(location string, resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return nil
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxHttpErrorBodyLength+1))
+	if len(body) > maxHttpErrorBodyLength {
+		body = append(body[:maxHttpErrorBodyLength-len("...")], "..."...)
+	}
+	return &HttpError{
+		StatusCode: resp.StatusCode,
+		Url:        location,
+		Body:       string(body),
+	}
 }
 
-//TODO Reader / writer pattern (interface)
-func (a Asset) saveRemoteFile(src string, dest string) (err error) {
-	data, err := a.Client.readData(src)
+func (h HttpClient) openData(ctx context.Context, location string) (io.ReadCloser, error) {
+	location, err := getTargetUrl(h.CdnBaseUrl, location)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	return openHttpGetRequest(ctx, location, h.httpClient(timeoutClient{timeout: h.getDownloadIdleTimeout(), idle: true}))
+}
+
+func (l LocalClient) readData(ctx context.Context, location string) (data []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(filepath.Join(l.CdnBaseUrl, location))
+}
+
+func (l LocalClient) openData(ctx context.Context, location string) (data io.ReadCloser, err error) {
+	if err = ctx.Err(); err != nil {
+		return nil, err
 	}
-	return ioutil.WriteFile(dest, data, 0644)
+	return os.Open(filepath.Join(l.CdnBaseUrl, location))
 }
 
 //getPathToLatestMajor example: MyApp\beta\latest.txt -> pointing to the latest major
@@ -112,7 +437,6 @@ func (a Asset) getPathToImportedUpdateFile(cdnUpdateFile string) (localUpdateFil
 
 //getCdnSigPath example: MyApp\beta\2\MyApp_2.4.2.exe.minisig
 func (a Asset) getCdnSigPath(cdnUpdateFile string) (cdnSigPath string) {
-	const signatureSuffix = ".minisig"
 	return fmt.Sprint(cdnUpdateFile, signatureSuffix)
 }
 
@@ -1,15 +1,21 @@
 package updater
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/Flaque/filet"
 	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestLocalClientReadData(t *testing.T) {
@@ -23,7 +29,7 @@ func TestLocalClientReadData(t *testing.T) {
 		Client: LocalClient{CdnBaseUrl: CdnBaseUrl},
 	}
 	//act
-	got, err := testAsset.Client.readData(testFile)
+	got, err := testAsset.Client.readData(context.Background(), testFile)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -49,12 +55,11 @@ func TestHttpClientReadData(t *testing.T) {
 		}
 	}))
 	defer server.Close()
-	httpImplementation = server.Client()
 	var testAsset = Asset{
-		Client: HttpClient{CdnBaseUrl: server.URL},
+		Client: HttpClient{CdnBaseUrl: server.URL, Client: server.Client()},
 	}
 	//act
-	got, _ := testAsset.Client.readData(testFileLocation)
+	got, _ := testAsset.Client.readData(context.Background(), testFileLocation)
 	//assert
 	assert.Equal(t, expected, string(got))
 }
@@ -564,7 +569,7 @@ func TestAsset_importFile(t *testing.T) {
 				Specs:         tt.fields.Specs,
 				TargetFolder:  tt.fields.TargetFolder,
 			}
-			if err := asset.saveRemoteFile(tt.args.src, tt.args.dest); (err != nil) != tt.wantErr {
+			if err := asset.saveRemoteFile(context.Background(), tt.args.src, tt.args.dest, pinnedFile{}); (err != nil) != tt.wantErr {
 				_ = os.Remove(src)
 				_ = os.Remove(dest)
 				t.Errorf("saveRemoteFile() error = %v, wantErr %v", err, tt.wantErr)
@@ -574,3 +579,246 @@ func TestAsset_importFile(t *testing.T) {
 		_ = os.Remove(dest)
 	}
 }
+
+func TestHttpClientReadDataStatusCodes(t *testing.T) {
+	longBody := strings.Repeat("x", 2*maxHttpErrorBodyLength)
+	tests := []struct {
+		name       string
+		statusCode int
+		body       string
+		want       string
+		wantErr    *HttpError
+	}{
+		{"ok", http.StatusOK, "1.0.0", "1.0.0", nil},
+		{"no content", http.StatusNoContent, "", "", nil},
+		{"not found", http.StatusNotFound, "<html>not found</html>", "", &HttpError{StatusCode: http.StatusNotFound, Body: "<html>not found</html>"}},
+		{"server error with truncated body", http.StatusInternalServerError, longBody, "", &HttpError{StatusCode: http.StatusInternalServerError, Body: longBody[:maxHttpErrorBodyLength-3] + "..."}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			//arrange
+			server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(tt.statusCode)
+				_, _ = rw.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+			client := HttpClient{CdnBaseUrl: server.URL, Client: server.Client()}
+
+			//act
+			got, err := client.readData(context.Background(), "MyApp/beta/latest.txt")
+
+			//assert
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, string(got))
+				return
+			}
+			var httpErr *HttpError
+			if assert.True(t, errors.As(err, &httpErr)) {
+				assert.Equal(t, tt.wantErr.StatusCode, httpErr.StatusCode)
+				assert.Equal(t, tt.wantErr.Body, httpErr.Body)
+				assert.LessOrEqual(t, len(httpErr.Body), maxHttpErrorBodyLength)
+				assert.Equal(t, server.URL+"/MyApp/beta/latest.txt", httpErr.Url)
+			}
+			assert.Nil(t, got)
+		})
+	}
+}
+
+type fakeBody struct {
+	io.Reader
+	closed bool
+}
+
+func (b *fakeBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+type fakeHttpClient struct {
+	resp *http.Response
+}
+
+func (f fakeHttpClient) Do(req *http.Request) (*http.Response, error) {
+	return f.resp, nil
+}
+
+func Test_readHttpGetRequestContentLengthMismatch(t *testing.T) {
+	//arrange
+	body := &fakeBody{Reader: strings.NewReader("1.0")}
+	client := fakeHttpClient{resp: &http.Response{StatusCode: http.StatusOK, ContentLength: 5, Body: body}}
+
+	//act
+	_, err := readHttpGetRequest(context.Background(), "https://example.org/MyApp/beta/1/latest.txt", client)
+
+	//assert
+	assert.True(t, errors.Is(err, ErrContentLengthMismatch))
+	assert.True(t, body.closed)
+}
+
+func Test_readHttpGetRequestClosesBodyOnError(t *testing.T) {
+	//arrange
+	body := &fakeBody{Reader: strings.NewReader("not found")}
+	client := fakeHttpClient{resp: &http.Response{StatusCode: http.StatusNotFound, ContentLength: -1, Body: body}}
+
+	//act
+	_, err := readHttpGetRequest(context.Background(), "https://example.org/MyApp/beta/1/latest.txt", client)
+
+	//assert
+	assert.Error(t, err)
+	assert.True(t, body.closed)
+}
+
+type staticClient struct {
+	body io.ReadCloser
+}
+
+func (s staticClient) readData(ctx context.Context, location string) ([]byte, error) {
+	return ioutil.ReadAll(s.body)
+}
+
+func (s staticClient) openData(ctx context.Context, location string) (io.ReadCloser, error) {
+	return s.body, nil
+}
+
+func TestHttpClientReadDataHonoursContextAndTimeout(t *testing.T) {
+	//arrange
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		select {
+		case <-release:
+		case <-req.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+	client := HttpClient{CdnBaseUrl: server.URL, Client: server.Client()}
+
+	//act
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := client.readData(ctx, "MyApp/beta/latest.txt")
+
+	//assert
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	//act
+	client.Timeout = 50 * time.Millisecond
+	_, err = client.readData(context.Background(), "MyApp/beta/latest.txt")
+
+	//assert
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestHttpClientOpenDataIdleTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		wantErr bool
+	}{
+		{"stalled before headers", func(rw http.ResponseWriter, req *http.Request) {
+			select {
+			case <-release:
+			case <-req.Context().Done():
+			}
+		}, true},
+		{"stalled after headers", func(rw http.ResponseWriter, req *http.Request) {
+			_, _ = rw.Write([]byte("Hello"))
+			rw.(http.Flusher).Flush()
+			select {
+			case <-release:
+			case <-req.Context().Done():
+			}
+		}, true},
+		{"slow but steady", func(rw http.ResponseWriter, req *http.Request) {
+			for i := 0; i < 10; i++ {
+				_, _ = rw.Write([]byte("Hello"))
+				rw.(http.Flusher).Flush()
+				time.Sleep(20 * time.Millisecond)
+			}
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			//arrange
+			server := httptest.NewServer(tt.handler)
+			defer server.Close()
+			client := HttpClient{CdnBaseUrl: server.URL, Client: server.Client(), DownloadIdleTimeout: 100 * time.Millisecond}
+
+			//act
+			var got []byte
+			body, err := client.openData(context.Background(), "MyApp/beta/1/MyApp_1.0.1.exe")
+			if err == nil {
+				got, err = ioutil.ReadAll(body)
+				_ = body.Close()
+			}
+
+			//assert
+			if tt.wantErr {
+				assert.True(t, errors.Is(err, context.DeadlineExceeded), "got %v", err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, strings.Repeat("Hello", 10), string(got))
+		})
+	}
+}
+
+func TestHttpClientHeaders(t *testing.T) {
+	//arrange
+	newServer := func(token string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if req.Header.Get("Authorization") != "Bearer "+token || req.Header.Get("User-Agent") != "myCore/1.0.0" {
+				rw.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_, _ = rw.Write([]byte(token))
+		}))
+	}
+	first, second := newServer("first"), newServer("second")
+	defer first.Close()
+	defer second.Close()
+	newClient := func(server *httptest.Server, token string) HttpClient {
+		return HttpClient{
+			CdnBaseUrl: server.URL,
+			Transport:  server.Client().Transport,
+			Header:     http.Header{"User-Agent": []string{"myCore/1.0.0"}},
+			HeaderProvider: func(req *http.Request) error {
+				req.Header.Set("Authorization", "Bearer "+token)
+				return nil
+			},
+		}
+	}
+
+	//act
+	gotFirst, errFirst := newClient(first, "first").readData(context.Background(), "latest.txt")
+	gotSecond, errSecond := newClient(second, "second").readData(context.Background(), "latest.txt")
+	_, errWrongToken := newClient(second, "first").readData(context.Background(), "latest.txt")
+
+	//assert
+	assert.NoError(t, errFirst)
+	assert.NoError(t, errSecond)
+	assert.Equal(t, "first", string(gotFirst))
+	assert.Equal(t, "second", string(gotSecond))
+	var httpErr *HttpError
+	assert.True(t, errors.As(errWrongToken, &httpErr))
+}
+
+func TestHttpClientHeaderProviderError(t *testing.T) {
+	//arrange
+	providerErr := errors.New("token expired")
+	client := HttpClient{
+		CdnBaseUrl: "http://127.0.0.1:1",
+		HeaderProvider: func(req *http.Request) error {
+			return providerErr
+		},
+	}
+
+	//act
+	_, err := client.readData(context.Background(), "latest.txt")
+
+	//assert
+	assert.True(t, errors.Is(err, providerErr))
+}
@@ -0,0 +1,37 @@
+package updater
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+)
+
+// FSClient
+// A Client reading the updates tree from an fs.FS, e.g. an embed.FS, a zip archive opened with zip.OpenReader or a
+// fstest.MapFS in tests. Root is the directory of the tree inside FS, the root of FS if empty.
+type FSClient struct {
+	FS   fs.FS
+	Root string
+}
+
+func (f FSClient) readData(ctx context.Context, location string) (data []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	return fs.ReadFile(f.FS, f.getFsPath(location))
+}
+
+func (f FSClient) openData(ctx context.Context, location string) (data io.ReadCloser, err error) {
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	return f.FS.Open(f.getFsPath(location))
+}
+
+// getFsPath converts the OS specific location into a slash separated path as fs.FS expects it.
+// example: MyApp\beta\latest.txt -> updates/MyApp/beta/latest.txt
+func (f FSClient) getFsPath(location string) string {
+	return path.Join(filepath.ToSlash(f.Root), filepath.ToSlash(location))
+}
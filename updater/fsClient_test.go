@@ -0,0 +1,76 @@
+package updater
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io/fs"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFSClientReadData(t *testing.T) {
+	tests := []struct {
+		name     string
+		client   FSClient
+		location string
+		want     string
+		wantErr  error
+	}{
+		{"root of fs", FSClient{FS: fstest.MapFS{"MyApp/beta/latest.txt": {Data: []byte("1")}}}, filepath.Join("MyApp", "beta", "latest.txt"), "1", nil},
+		{"sub directory", FSClient{FS: fstest.MapFS{"updates/MyApp/beta/latest.txt": {Data: []byte("2")}}, Root: "updates"}, filepath.Join("MyApp", "beta", "latest.txt"), "2", nil},
+		{"missing file", FSClient{FS: fstest.MapFS{}}, filepath.Join("MyApp", "beta", "latest.txt"), "", fs.ErrNotExist},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.client.readData(context.Background(), tt.location)
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr))
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestFSClientCheckForUpdatesInZip(t *testing.T) {
+	//arrange
+	buffer := &bytes.Buffer{}
+	archive := zip.NewWriter(buffer)
+	for name, content := range map[string]string{
+		"MyApp/beta/latest.txt":        "1",
+		"MyApp/beta/1/latest.txt":      "1.0.1",
+		"MyApp/beta/1/1.0.1.json":      `[{"asset":"MyApp","channel":"beta","version":"1.0.1","specs":{},"filePath":"MyApp/beta/1/MyApp_1.0.1.exe"}]`,
+		"MyApp/beta/1/MyApp_1.0.1.exe": "update",
+	} {
+		file, _ := archive.Create(name)
+		_, _ = file.Write([]byte(content))
+	}
+	_ = archive.Close()
+	reader, err := zip.NewReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+	assert.NoError(t, err)
+	asset := Asset{AssetName: "MyApp", AssetVersion: "1.0.0", Channel: "beta", Client: FSClient{FS: reader}, Specs: map[string]string{}}
+
+	//act
+	updates, updateFound, err := asset.CheckForUpdates()
+
+	//assert
+	assert.NoError(t, err)
+	assert.True(t, updateFound)
+	if !assert.Len(t, updates, 1) {
+		return
+	}
+	assert.Equal(t, "1.0.1", updates[0].Version)
+	file, err := asset.Client.openData(context.Background(), updates[0].Path)
+	if assert.NoError(t, err) {
+		got, _ := ioutil.ReadAll(file)
+		_ = file.Close()
+		assert.Equal(t, "update", string(got))
+		assert.Equal(t, int64(len("update")), getSize(file))
+	}
+}
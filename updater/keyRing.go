@@ -0,0 +1,196 @@
+package updater
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/jedisct1/go-minisign"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrUnknownKey is returned if a signature was created by a key which is not in the KeyRing.
+	ErrUnknownKey = errors.New("unknown signature key")
+	// ErrRevokedKey is returned if a signature was created by a key revoked in the KeyRing.
+	ErrRevokedKey = errors.New("revoked signature key")
+	// ErrSignatureInvalid is returned if a signature does not match the signed file.
+	ErrSignatureInvalid = errors.New("invalid signature")
+)
+
+// FormatKeyId
+// Formats a key id like minisign displays it, e.g. "E3B0C44298FC1C14".
+func FormatKeyId(keyId [8]byte) string {
+	return fmt.Sprintf("%016X", binary.LittleEndian.Uint64(keyId[:]))
+}
+
+const (
+	// keysDir contains the rotated public keys in the updates source, e.g. keys/E3B0C44298FC1C14.pub
+	keysDir         = "keys"
+	publicKeySuffix = ".pub"
+	// maxKeyRotations limits how many rotated keys are followed to reach a trusted key.
+	maxKeyRotations = 8
+)
+
+// KeyError
+// Describes why a signature of the key KeyId was rejected. Err is ErrUnknownKey, ErrRevokedKey, ErrSignatureInvalid or
+// an error reading the signature.
+type KeyError struct {
+	KeyId string
+	Err   error
+}
+
+func (e *KeyError) Error() string {
+	return "key " + e.KeyId + ": " + e.Err.Error()
+}
+
+func (e *KeyError) Unwrap() error {
+	return e.Err
+}
+
+// KeyRing
+// The minisign public keys trusted to sign updates. Signatures are verified with the key matching their key id.
+// Create it with NewKeyRing, it is safe for concurrent use by several assets.
+type KeyRing struct {
+	mutex   sync.RWMutex
+	keys    map[[8]byte]minisign.PublicKey
+	revoked map[[8]byte]bool
+}
+
+// NewKeyRing
+// Creates a KeyRing trusting the base64 encoded public keys, like UpdateFilesPubKey.
+func NewKeyRing(publicKeys ...string) (*KeyRing, error) {
+	k := &KeyRing{keys: map[[8]byte]minisign.PublicKey{}, revoked: map[[8]byte]bool{}}
+	for _, publicKey := range publicKeys {
+		if err := k.Add(publicKey); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// Add
+// Trusts the base64 encoded public key, unless its key id was revoked.
+func (k *KeyRing) Add(publicKey string) error {
+	pub, err := minisign.NewPublicKey(strings.TrimSpace(publicKey))
+	if err != nil {
+		return err
+	}
+	k.add(pub)
+	return nil
+}
+
+// Revoke
+// Rejects signatures of the key with keyId, formatted like FormatKeyId, even if it is added again or learned by rotation.
+func (k *KeyRing) Revoke(keyId string) error {
+	id, err := parseKeyId(keyId)
+	if err != nil {
+		return err
+	}
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.revoked[id] = true
+	delete(k.keys, id)
+	return nil
+}
+
+func (k *KeyRing) add(pub minisign.PublicKey) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if !k.revoked[pub.KeyId] {
+		k.keys[pub.KeyId] = pub
+	}
+}
+
+// getKey returns the trusted key with keyId, or an error wrapping ErrUnknownKey or ErrRevokedKey.
+func (k *KeyRing) getKey(keyId [8]byte) (pub minisign.PublicKey, err error) {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	if k.revoked[keyId] {
+		return minisign.PublicKey{}, &KeyError{KeyId: FormatKeyId(keyId), Err: ErrRevokedKey}
+	}
+	pub, found := k.keys[keyId]
+	if !found {
+		return minisign.PublicKey{}, &KeyError{KeyId: FormatKeyId(keyId), Err: ErrUnknownKey}
+	}
+	return pub, nil
+}
+
+// verify checks the signature of the content read from r with the key of the signature.
+func (k *KeyRing) verify(r io.Reader, sig minisign.Signature) error {
+	pub, err := k.getKey(sig.KeyId)
+	if err != nil {
+		return err
+	}
+	sigValid, err := verifySignature(pub, r, sig)
+	if err != nil {
+		return &KeyError{KeyId: FormatKeyId(sig.KeyId), Err: err}
+	}
+	if !sigValid {
+		return &KeyError{KeyId: FormatKeyId(sig.KeyId), Err: ErrSignatureInvalid}
+	}
+	return nil
+}
+
+// Verify
+// Verifies the minisign signature of the content read from r with the trusted key of its key id and returns the fields
+// of its trusted comment, e.g. for the uploader before it signs published files again.
+func (k *KeyRing) Verify(r io.Reader, signature string) (signed SignedFile, err error) {
+	sig, err := minisign.DecodeSignature(signature)
+	if err != nil {
+		return SignedFile{}, fmt.Errorf("%w: %v", ErrSignatureInvalid, err)
+	}
+	if err = k.verify(r, sig); err != nil {
+		return SignedFile{}, err
+	}
+	return ParseTrustedComment(sig.TrustedComment)
+}
+
+// learnKey adds the rotated key keyId, published by "uploader rotate" as keys/{KeyId}.pub with a signature of a trusted
+// key, which may itself be a rotated key.
+func (k *KeyRing) learnKey(ctx context.Context, client Client, keyId [8]byte, rotations int) error {
+	if _, err := k.getKey(keyId); !errors.Is(err, ErrUnknownKey) || rotations >= maxKeyRotations {
+		return err
+	}
+	keyFile := path.Join(keysDir, FormatKeyId(keyId)+publicKeySuffix)
+	publicKey, err := client.readData(ctx, keyFile)
+	if err != nil {
+		return &KeyError{KeyId: FormatKeyId(keyId), Err: fmt.Errorf("%w: %v", ErrUnknownKey, err)}
+	}
+	pub, err := minisign.DecodePublicKey(string(publicKey))
+	if err != nil {
+		return err
+	}
+	if pub.KeyId != keyId {
+		return &KeyError{KeyId: FormatKeyId(keyId), Err: fmt.Errorf("%s contains key %s", keyFile, FormatKeyId(pub.KeyId))}
+	}
+	signature, err := client.readData(ctx, keyFile+signatureSuffix)
+	if err != nil {
+		return &KeyError{KeyId: FormatKeyId(keyId), Err: fmt.Errorf("%w: %v", ErrUnknownKey, err)}
+	}
+	sig, err := minisign.DecodeSignature(string(signature))
+	if err != nil {
+		return err
+	}
+	if err = k.learnKey(ctx, client, sig.KeyId, rotations+1); err != nil {
+		return err
+	}
+	if err = k.verify(strings.NewReader(string(publicKey)), sig); err != nil {
+		return err
+	}
+	k.add(pub)
+	return nil
+}
+
+func parseKeyId(keyId string) (id [8]byte, err error) {
+	value, err := strconv.ParseUint(strings.TrimSpace(keyId), 16, 64)
+	if err != nil || len(strings.TrimSpace(keyId)) != 16 {
+		return id, fmt.Errorf("invalid key id %q", keyId)
+	}
+	binary.LittleEndian.PutUint64(id[:], value)
+	return id, nil
+}
@@ -0,0 +1,122 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"github.com/Flaque/filet"
+	"github.com/haevg-rz/go-updater/internal/signing"
+	"github.com/jedisct1/go-minisign"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestKeyRing_verify(t *testing.T) {
+	first, second, unknown, revoked := newTestSecretKey(t), newTestSecretKey(t), newTestSecretKey(t), newTestSecretKey(t)
+	keyRing, err := NewKeyRing(first.PublicKey(), second.PublicKey(), revoked.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, keyRing.Revoke(FormatKeyId(revoked.KeyId)))
+	assert.NoError(t, keyRing.Add(revoked.PublicKey()))
+	tests := []struct {
+		name    string
+		key     signing.SecretKey
+		content string
+		wantErr error
+	}{
+		{"first key", first, "Hello Gophers", nil},
+		{"second key", second, "Hello Gophers", nil},
+		{"unknown key", unknown, "Hello Gophers", ErrUnknownKey},
+		{"revoked key", revoked, "Hello Gophers", ErrRevokedKey},
+		{"tampered content", first, "Hello Attackers", ErrSignatureInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sig, _ := minisign.DecodeSignature(signTestContent(t, tt.key, prehashedSignatureAlgorithm, "Hello Gophers", "timestamp:1612345678"))
+			err := keyRing.verify(strings.NewReader(tt.content), sig)
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.True(t, errors.Is(err, tt.wantErr), err)
+			var keyErr *KeyError
+			if assert.True(t, errors.As(err, &keyErr)) {
+				assert.Equal(t, FormatKeyId(tt.key.KeyId), keyErr.KeyId)
+			}
+		})
+	}
+}
+
+func TestKeyRing_Verify(t *testing.T) {
+	key := newTestSecretKey(t)
+	keyRing, _ := NewKeyRing(key.PublicKey())
+	signature := signTestContent(t, key, prehashedSignatureAlgorithm, "Hello Gophers", "timestamp:1612345678")
+
+	signed, err := keyRing.Verify(strings.NewReader("Hello Gophers"), signature)
+	assert.NoError(t, err)
+	assert.Equal(t, SignedFile{Timestamp: 1612345678}, signed)
+
+	_, err = keyRing.Verify(strings.NewReader("Hello Attackers"), signature)
+	assert.True(t, errors.Is(err, ErrSignatureInvalid), err)
+
+	_, err = keyRing.Verify(strings.NewReader("Hello Gophers"), "no signature")
+	assert.True(t, errors.Is(err, ErrSignatureInvalid), err)
+}
+
+func TestAsset_verifyFileSignatureLearnsRotatedKeys(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	trusted, rotated, current := newTestSecretKey(t), newTestSecretKey(t), newTestSecretKey(t)
+	rotatedKeyFile := "keys/" + FormatKeyId(rotated.KeyId) + ".pub"
+	currentKeyFile := "keys/" + FormatKeyId(current.KeyId) + ".pub"
+	cdn := fstest.MapFS{
+		rotatedKeyFile:              {Data: []byte(rotated.EncodePublicKey())},
+		rotatedKeyFile + ".minisig": {Data: []byte(signTestContent(t, trusted, prehashedSignatureAlgorithm, rotated.EncodePublicKey(), "timestamp:1612345678"))},
+		currentKeyFile:              {Data: []byte(current.EncodePublicKey())},
+		currentKeyFile + ".minisig": {Data: []byte(signTestContent(t, rotated, prehashedSignatureAlgorithm, current.EncodePublicKey(), "timestamp:1612345678"))},
+		"MyApp_1.0.1.txt.minisig":   {Data: []byte(signTestContent(t, current, prehashedSignatureAlgorithm, "Hello Gophers", "timestamp:1612345678"))},
+	}
+	file := filet.TmpFile(t, "", "Hello Gophers").Name()
+	newAsset := func(learnRotatedKeys bool, revoked ...signing.SecretKey) Asset {
+		keyRing, _ := NewKeyRing(trusted.PublicKey())
+		for _, key := range revoked {
+			_ = keyRing.Revoke(FormatKeyId(key.KeyId))
+		}
+		return Asset{Client: FSClient{FS: cdn}, KeyRing: keyRing, LearnRotatedKeys: learnRotatedKeys}
+	}
+	tests := []struct {
+		name    string
+		asset   Asset
+		wantErr error
+	}{
+		{"learns rotated keys", newAsset(true), nil},
+		{"does not learn keys", newAsset(false), ErrUnknownKey},
+		{"revoked rotated key", newAsset(true, rotated), ErrRevokedKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			//act
+			err := tt.asset.verifyFileSignature(context.Background(), file, "MyApp_1.0.1.txt.minisig", SignedFile{})
+
+			//assert
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr), err)
+				return
+			}
+			assert.NoError(t, err)
+			_, err = tt.asset.KeyRing.getKey(current.KeyId)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func Test_parseKeyId(t *testing.T) {
+	key := newTestSecretKey(t)
+	got, err := parseKeyId(FormatKeyId(key.KeyId))
+	assert.NoError(t, err)
+	assert.Equal(t, key.KeyId, got)
+	_, err = parseKeyId("E3B0C442")
+	assert.Error(t, err)
+}
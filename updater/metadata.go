@@ -0,0 +1,135 @@
+package updater
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrMetadataMismatch is returned if the trusted comment of a signature does not bind it to the expected file.
+var ErrMetadataMismatch = errors.New("signature does not match the file")
+
+const trustedCommentPrefix = "trusted comment: "
+
+// SignedFile
+// The fields of the trusted comment of a signature, binding it to a file of the updates source. They are formatted as
+// tab separated key:value pairs, e.g. "timestamp:1612345678\tfile:MyApp/beta/1/latest.txt\tasset:MyApp\tchannel:beta\tversion:1.2.3".
+type SignedFile struct {
+	// Timestamp and Expires are unix times of the signature and the end of its validity. Expires is optional.
+	Timestamp int64
+	Expires   int64
+	// File is the slash separated path of the file in the updates source.
+	File    string
+	Asset   string
+	Channel string
+	Version string
+}
+
+// TrustedComment
+// Formats the fields as trusted comment, empty fields are omitted.
+func (s SignedFile) TrustedComment() string {
+	var fields []string
+	if s.Timestamp != 0 {
+		fields = append(fields, "timestamp:"+strconv.FormatInt(s.Timestamp, 10))
+	}
+	if s.Expires != 0 {
+		fields = append(fields, "expires:"+strconv.FormatInt(s.Expires, 10))
+	}
+	for _, field := range [][2]string{{"file", s.File}, {"asset", s.Asset}, {"channel", s.Channel}, {"version", s.Version}} {
+		if field[1] != "" {
+			fields = append(fields, field[0]+":"+field[1])
+		}
+	}
+	return strings.Join(fields, "\t")
+}
+
+// ParseTrustedComment
+// Parses the fields of a trusted comment, with or without the "trusted comment: " prefix. Unknown fields are ignored.
+func ParseTrustedComment(trustedComment string) (signed SignedFile, err error) {
+	for _, field := range strings.Split(strings.TrimPrefix(trustedComment, trustedCommentPrefix), "\t") {
+		parts := strings.SplitN(field, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "timestamp":
+			if signed.Timestamp, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+				return SignedFile{}, fmt.Errorf("invalid timestamp in trusted comment: %v", err)
+			}
+		case "expires":
+			if signed.Expires, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+				return SignedFile{}, fmt.Errorf("invalid expires in trusted comment: %v", err)
+			}
+		case "file":
+			signed.File = parts[1]
+		case "asset":
+			signed.Asset = parts[1]
+		case "channel":
+			signed.Channel = parts[1]
+		case "version":
+			signed.Version = parts[1]
+		}
+	}
+	return signed, nil
+}
+
+// matches checks that the trusted comment binds the signature to the file, asset, channel and version of s and returns
+// its fields. Empty fields of s are not checked.
+func (s SignedFile) matches(trustedComment string) (signed SignedFile, err error) {
+	signed, err = ParseTrustedComment(trustedComment)
+	if err != nil {
+		return SignedFile{}, err
+	}
+	expected := [][3]string{
+		{"file", filepath.ToSlash(s.File), signed.File},
+		{"asset", s.Asset, signed.Asset},
+		{"channel", s.Channel, signed.Channel},
+		{"version", s.Version, signed.Version},
+	}
+	for _, field := range expected {
+		if field[1] != "" && field[1] != field[2] {
+			return SignedFile{}, fmt.Errorf("%w: %s is signed for %s %q instead of %q", ErrMetadataMismatch, filepath.ToSlash(s.File), field[0], field[2], field[1])
+		}
+	}
+	return signed, nil
+}
+
+// getSignedFile returns the fields the signature of the file at location has to be bound to.
+func (a Asset) getSignedFile(location string, version string) SignedFile {
+	return SignedFile{File: filepath.ToSlash(location), Asset: a.AssetName, Channel: a.Channel, Version: version}
+}
+
+// readMetadata reads a latest.txt or version json. In repository mode it has to match the targets metadata. With
+// RequireSignedMetadata its signature is verified, has to be bound to the file and version and must neither be expired
+// nor older than signatures verified before.
+func (a Asset) readMetadata(ctx context.Context, location string, version string) (data []byte, err error) {
+	data, err = a.Client.readData(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+	if err = a.verifyMetadata(ctx, location, data, version); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// verifyMetadata verifies the data of a latest.txt or version json read from location like readMetadata.
+func (a Asset) verifyMetadata(ctx context.Context, location string, data []byte, version string) error {
+	if a.Repository != nil {
+		if err := a.Repository.verifyTarget(location, bytes.NewReader(data)); err != nil {
+			return err
+		}
+	}
+	if !a.RequireSignedMetadata {
+		return nil
+	}
+	signed, err := a.verifySignedFile(ctx, bytes.NewReader(data), location+signatureSuffix, a.getSignedFile(location, version))
+	if err != nil {
+		return err
+	}
+	return a.checkReplay(signed)
+}
@@ -0,0 +1,122 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"github.com/haevg-rz/go-updater/internal/signing"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"testing/fstest"
+)
+
+// signedTestCdn returns an updates source of MyApp 1.0.1 in the beta channel with signed metadata.
+func signedTestCdn(t *testing.T, key signing.SecretKey) fstest.MapFS {
+	return signedTestCdnAt(t, key, "1.0.1", SignedFile{Timestamp: 1612345678})
+}
+
+// signedTestCdnAt returns an updates source of version in the beta channel of MyApp, signed with the Timestamp and
+// Expires of signed.
+func signedTestCdnAt(t *testing.T, key signing.SecretKey, version string, signed SignedFile) fstest.MapFS {
+	files := testUpdateFiles("MyApp", version)
+	cdn := newTestCdn(files)
+	for file, content := range files {
+		signed.File, signed.Asset, signed.Channel, signed.Version = file, "MyApp", "beta", version
+		if file == "MyApp/beta/latest.txt" {
+			signed.Version = ""
+		}
+		cdn[file+signatureSuffix] = &fstest.MapFile{Data: []byte(signTestContent(t, key, prehashedSignatureAlgorithm, content, signed.TrustedComment()))}
+	}
+	return cdn
+}
+
+func TestAsset_CheckForUpdatesSignedMetadata(t *testing.T) {
+	//arrange
+	key := newTestSecretKey(t)
+	keyRing, _ := NewKeyRing(key.PublicKey())
+	newAsset := func(cdn fstest.MapFS) Asset {
+		return Asset{AssetName: "MyApp", AssetVersion: "1.0.0", Channel: "beta", Specs: map[string]string{}, Client: FSClient{FS: cdn}, KeyRing: keyRing, RequireSignedMetadata: true}
+	}
+	unsigned := signedTestCdn(t, key)
+	delete(unsigned, "MyApp/beta/latest.txt.minisig")
+	tampered := signedTestCdn(t, key)
+	tampered["MyApp/beta/latest.txt"] = &fstest.MapFile{Data: []byte("2")}
+	otherChannel := signedTestCdn(t, key)
+	otherChannel["MyApp/stable/latest.txt"], otherChannel["MyApp/stable/latest.txt.minisig"] = otherChannel["MyApp/beta/latest.txt"], otherChannel["MyApp/beta/latest.txt.minisig"]
+	unsignedVersionJson := signedTestCdn(t, key)
+	delete(unsignedVersionJson, "MyApp/beta/1/1.0.1.json.minisig")
+	tamperedMajorLatest := signedTestCdn(t, key)
+	tamperedMajorLatest["MyApp/beta/1/latest.txt"] = &fstest.MapFile{Data: []byte("1.0.2")}
+	otherChannelAsset := newAsset(otherChannel)
+	otherChannelAsset.Channel = "stable"
+	tests := []struct {
+		name            string
+		asset           Asset
+		wantUpdateFound bool
+		wantErr         bool
+		wantErrIs       error
+	}{
+		{"signed metadata", newAsset(signedTestCdn(t, key)), true, false, nil},
+		{"missing signature", newAsset(unsigned), false, true, ErrSignatureMissing},
+		{"tampered latest.txt", newAsset(tampered), false, true, ErrSignatureInvalid},
+		{"missing signature of version json", newAsset(unsignedVersionJson), false, true, ErrSignatureMissing},
+		{"tampered major latest.txt", newAsset(tamperedMajorLatest), false, true, ErrSignatureInvalid},
+		{"latest.txt of another channel", otherChannelAsset, false, true, ErrMetadataMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			//act
+			_, updateFound, err := tt.asset.CheckForUpdatesContext(context.Background())
+
+			//assert
+			assert.Equal(t, tt.wantUpdateFound, updateFound)
+			assert.Equal(t, tt.wantErr, err != nil, err)
+			if tt.wantErrIs != nil {
+				assert.True(t, errors.Is(err, tt.wantErrIs), err)
+			}
+		})
+	}
+}
+
+func TestAsset_getUpdateFromJsonSignedForOtherVersion(t *testing.T) {
+	//arrange
+	key := newTestSecretKey(t)
+	keyRing, _ := NewKeyRing(key.PublicKey())
+	cdn := signedTestCdn(t, key)
+	// a validly signed version json of an older version, served as version json of 1.0.2
+	cdn["MyApp/beta/1/1.0.2.json"], cdn["MyApp/beta/1/1.0.2.json.minisig"] = cdn["MyApp/beta/1/1.0.1.json"], cdn["MyApp/beta/1/1.0.1.json.minisig"]
+	asset := Asset{AssetName: "MyApp", Channel: "beta", Client: FSClient{FS: cdn}, KeyRing: keyRing, RequireSignedMetadata: true}
+
+	//act
+	_, err := asset.getUpdateFromJson(context.Background(), "1", "1.0.2")
+
+	//assert
+	assert.True(t, errors.Is(err, ErrMetadataMismatch), err)
+}
+
+func TestAsset_getLatestVersionInMajorDirSignedForOtherVersion(t *testing.T) {
+	//arrange
+	key := newTestSecretKey(t)
+	keyRing, _ := NewKeyRing(key.PublicKey())
+	cdn := signedTestCdn(t, key)
+	signed := SignedFile{Timestamp: 1612345678, File: "MyApp/beta/1/latest.txt", Asset: "MyApp", Channel: "beta", Version: "1.0.0"}
+	cdn["MyApp/beta/1/latest.txt.minisig"] = &fstest.MapFile{Data: []byte(signTestContent(t, key, prehashedSignatureAlgorithm, "1.0.1", signed.TrustedComment()))}
+	asset := Asset{AssetName: "MyApp", Channel: "beta", Client: FSClient{FS: cdn}, KeyRing: keyRing, RequireSignedMetadata: true}
+
+	//act
+	_, err := asset.getLatestVersionInMajorDir(context.Background(), "1")
+
+	//assert
+	assert.True(t, errors.Is(err, ErrMetadataMismatch), err)
+}
+
+func TestParseTrustedComment(t *testing.T) {
+	signed := SignedFile{Timestamp: 1612345678, File: "MyApp/beta/1/latest.txt", Asset: "MyApp", Channel: "beta", Version: "1.2.3"}
+	assert.Equal(t, "timestamp:1612345678\tfile:MyApp/beta/1/latest.txt\tasset:MyApp\tchannel:beta\tversion:1.2.3", signed.TrustedComment())
+
+	got, err := ParseTrustedComment("trusted comment: " + signed.TrustedComment() + "\thashed")
+	assert.NoError(t, err)
+	assert.Equal(t, signed, got)
+
+	_, err = ParseTrustedComment("timestamp:yesterday")
+	assert.Error(t, err)
+}
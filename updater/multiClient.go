@@ -0,0 +1,130 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// MultiClient
+// A Client reading from an ordered list of mirrors, e.g. HttpClients of several CDNs and a LocalClient of a file share.
+// A failed request is repeated on the next mirror and later requests start with the last healthy mirror.
+// Create it with NewMultiClient.
+type MultiClient struct {
+	Mirrors []Client
+	// MetadataFromPrimary reads latest.txt files and version jsons from the first mirror only, so outdated mirrors can not
+	// hold back updates. Updates and signatures are read from any mirror, as signatures protect their integrity.
+	MetadataFromPrimary bool
+
+	mutex   sync.Mutex
+	healthy int
+}
+
+// MirrorsError
+// Returned by MultiClient if all mirrors failed. Errors holds the error of every mirror tried.
+type MirrorsError struct {
+	Location string
+	Errors   []error
+}
+
+func (e *MirrorsError) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		messages = append(messages, err.Error())
+	}
+	return "all mirrors failed for " + e.Location + ": " + strings.Join(messages, "; ")
+}
+
+// Unwrap returns the error of the last mirror tried.
+func (e *MirrorsError) Unwrap() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e.Errors[len(e.Errors)-1]
+}
+
+// NewMultiClient
+// Creates a MultiClient trying the mirrors in the given order.
+func NewMultiClient(mirrors ...Client) *MultiClient {
+	return &MultiClient{Mirrors: mirrors}
+}
+
+func (m *MultiClient) readData(ctx context.Context, location string) (data []byte, err error) {
+	err = m.try(ctx, location, func(mirror Client) (err error) {
+		data, err = mirror.readData(ctx, location)
+		return err
+	})
+	return data, err
+}
+
+func (m *MultiClient) openData(ctx context.Context, location string) (data io.ReadCloser, err error) {
+	err = m.try(ctx, location, func(mirror Client) (err error) {
+		data, err = mirror.openData(ctx, location)
+		return err
+	})
+	return data, err
+}
+
+func (m *MultiClient) openDataRange(ctx context.Context, location string, offset int64, validator string) (data io.ReadCloser, info remoteFileInfo, err error) {
+	err = m.try(ctx, location, func(mirror Client) (err error) {
+		if resumable, ok := mirror.(rangeClient); ok {
+			data, info, err = resumable.openDataRange(ctx, location, offset, validator)
+			return err
+		}
+		data, err = mirror.openData(ctx, location)
+		info = remoteFileInfo{Size: getSize(data)}
+		return err
+	})
+	return data, info, err
+}
+
+// try calls read for the mirrors, starting with the last healthy one, until it succeeds.
+func (m *MultiClient) try(ctx context.Context, location string, read func(mirror Client) error) error {
+	if len(m.Mirrors) == 0 {
+		return errors.New("no mirrors configured")
+	}
+	mirrors := m.getMirrorOrder(location)
+	failed := &MirrorsError{Location: location}
+	for _, i := range mirrors {
+		err := read(m.Mirrors[i])
+		if err == nil {
+			m.setHealthy(i)
+			return nil
+		}
+		failed.Errors = append(failed.Errors, err)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	if len(failed.Errors) == 1 {
+		return failed.Errors[0]
+	}
+	return failed
+}
+
+func (m *MultiClient) getMirrorOrder(location string) (mirrors []int) {
+	if m.MetadataFromPrimary && isMetadata(location) {
+		return []int{0}
+	}
+	m.mutex.Lock()
+	first := m.healthy
+	m.mutex.Unlock()
+	for i := range m.Mirrors {
+		mirrors = append(mirrors, (first+i)%len(m.Mirrors))
+	}
+	return mirrors
+}
+
+func (m *MultiClient) setHealthy(mirror int) {
+	m.mutex.Lock()
+	m.healthy = mirror
+	m.mutex.Unlock()
+}
+
+// isMetadata reports whether location is a latest.txt or a version json, which decide what clients update to.
+func isMetadata(location string) bool {
+	return filepath.Base(location) == latestFileName || filepath.Ext(location) == ".json"
+}
@@ -0,0 +1,121 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"github.com/Flaque/filet"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+type countingClient struct {
+	Client
+	reads *int
+}
+
+func (c countingClient) readData(ctx context.Context, location string) ([]byte, error) {
+	*c.reads++
+	return c.Client.readData(ctx, location)
+}
+
+func (c countingClient) openData(ctx context.Context, location string) (io.ReadCloser, error) {
+	*c.reads++
+	return c.Client.openData(ctx, location)
+}
+
+func TestMultiClientFailsOver(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	share := writeTestCdn(t, map[string]string{"MyApp/beta/latest.txt": "1"})
+	cdnReads, shareReads := 0, 0
+	client := NewMultiClient(
+		countingClient{Client: HttpClient{CdnBaseUrl: server.URL, Client: server.Client()}, reads: &cdnReads},
+		countingClient{Client: LocalClient{CdnBaseUrl: share}, reads: &shareReads},
+	)
+
+	//act
+	first, errFirst := client.readData(context.Background(), filepath.Join("MyApp", "beta", "latest.txt"))
+	second, errSecond := client.readData(context.Background(), filepath.Join("MyApp", "beta", "latest.txt"))
+
+	//assert
+	assert.NoError(t, errFirst)
+	assert.NoError(t, errSecond)
+	assert.Equal(t, "1", string(first))
+	assert.Equal(t, "1", string(second))
+	assert.Equal(t, 1, cdnReads)
+	assert.Equal(t, 2, shareReads)
+}
+
+func TestMultiClientAllMirrorsFail(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	client := NewMultiClient(
+		LocalClient{CdnBaseUrl: filet.TmpDir(t, "")},
+		HttpClient{CdnBaseUrl: server.URL, Client: server.Client()},
+	)
+
+	//act
+	_, err := client.readData(context.Background(), "MyApp/beta/latest.txt")
+
+	//assert
+	var mirrorsErr *MirrorsError
+	if assert.True(t, errors.As(err, &mirrorsErr)) {
+		assert.Len(t, mirrorsErr.Errors, 2)
+	}
+	var httpErr *HttpError
+	assert.True(t, errors.As(err, &httpErr))
+}
+
+func TestMultiClientMetadataFromPrimary(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	primary := writeTestCdn(t, map[string]string{})
+	mirror := writeTestCdn(t, map[string]string{
+		"MyApp/beta/latest.txt":        "1",
+		"MyApp/beta/1/MyApp_1.0.1.exe": "update",
+	})
+	client := NewMultiClient(LocalClient{CdnBaseUrl: primary}, LocalClient{CdnBaseUrl: mirror})
+	client.MetadataFromPrimary = true
+
+	//act
+	_, metadataErr := client.readData(context.Background(), "MyApp/beta/latest.txt")
+	update, updateErr := client.openData(context.Background(), "MyApp/beta/1/MyApp_1.0.1.exe")
+
+	//assert
+	assert.Error(t, metadataErr)
+	if assert.NoError(t, updateErr) {
+		data, _ := ioutil.ReadAll(update)
+		_ = update.Close()
+		assert.Equal(t, "update", string(data))
+	}
+}
+
+func TestAsset_saveRemoteFileWithMultiClient(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	mirror := writeTestCdn(t, map[string]string{"MyApp/beta/1/MyApp_1.0.1.exe": "update"})
+	asset := Asset{
+		Client:       NewMultiClient(LocalClient{CdnBaseUrl: filet.TmpDir(t, "")}, LocalClient{CdnBaseUrl: mirror}),
+		TargetFolder: filet.TmpDir(t, ""),
+	}
+	dest := filepath.Join(asset.TargetFolder, "update_MyApp_1.0.1.exe")
+
+	//act
+	err := asset.saveRemoteFile(context.Background(), "MyApp/beta/1/MyApp_1.0.1.exe", dest, pinnedFile{})
+
+	//assert
+	assert.NoError(t, err)
+	got, _ := ioutil.ReadFile(dest)
+	assert.Equal(t, "update", string(got))
+}
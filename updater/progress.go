@@ -0,0 +1,92 @@
+package updater
+
+import (
+	"io"
+	"time"
+)
+
+// Phase
+// The step of an update reported to Asset.OnProgress.
+type Phase string
+
+const (
+	PhaseChecking    Phase = "checking"
+	PhaseDownloading Phase = "downloading"
+	PhaseVerifying   Phase = "verifying"
+	PhaseApplying    Phase = "applying"
+)
+
+// progressInterval limits how often download progress is reported.
+const progressInterval = 100 * time.Millisecond
+
+// Progress
+// Reported to Asset.OnProgress. Byte counts, Rate (bytes per second) and ETA are only set while downloading.
+// BytesTotal is -1 and ETA is 0 if the size of the download is unknown.
+type Progress struct {
+	Phase      Phase
+	BytesDone  int64
+	BytesTotal int64
+	Rate       float64
+	ETA        time.Duration
+}
+
+func (a Asset) reportPhase(phase Phase) {
+	if a.OnProgress != nil {
+		a.OnProgress(Progress{Phase: phase, BytesTotal: -1})
+	}
+}
+
+// trackDownload reports the bytes read from r. offset is the size of an already downloaded part and total the size
+// of the whole file or -1.
+func (a Asset) trackDownload(r io.Reader, offset int64, total int64) io.Reader {
+	if a.OnProgress == nil {
+		return r
+	}
+	now := time.Now()
+	p := &progressReader{
+		reader:     r,
+		onProgress: a.OnProgress,
+		offset:     offset,
+		done:       offset,
+		total:      total,
+		start:      now,
+	}
+	p.report(now)
+	return p
+}
+
+type progressReader struct {
+	reader       io.Reader
+	onProgress   func(progress Progress)
+	offset       int64
+	done         int64
+	total        int64
+	start        time.Time
+	lastReported time.Time
+}
+
+func (p *progressReader) Read(b []byte) (n int, err error) {
+	n, err = p.reader.Read(b)
+	p.done += int64(n)
+	now := time.Now()
+	if err == io.EOF || now.Sub(p.lastReported) >= progressInterval {
+		p.report(now)
+	}
+	return n, err
+}
+
+func (p *progressReader) report(now time.Time) {
+	p.lastReported = now
+	progress := Progress{
+		Phase:      PhaseDownloading,
+		BytesDone:  p.done,
+		BytesTotal: p.total,
+	}
+	if elapsed := now.Sub(p.start).Seconds(); elapsed > 0 {
+		progress.Rate = float64(p.done-p.offset) / elapsed
+	}
+	if progress.Rate > 0 && p.total >= p.done {
+		progress.ETA = time.Duration(float64(p.total-p.done) / progress.Rate * float64(time.Second))
+	}
+	p.onProgress(progress)
+}
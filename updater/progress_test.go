@@ -0,0 +1,80 @@
+package updater
+
+import (
+	"context"
+	"github.com/Flaque/filet"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAsset_saveRemoteFileReportsProgress(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	content := strings.Repeat("0123456789", 10000)
+	cdnBaseUrl := writeTestCdn(t, map[string]string{"MyApp/beta/1/MyApp_1.0.1.exe": content})
+	var reports []Progress
+	asset := Asset{
+		AssetName:    "MyApp",
+		Client:       LocalClient{CdnBaseUrl: cdnBaseUrl},
+		TargetFolder: filet.TmpDir(t, ""),
+		OnProgress: func(progress Progress) {
+			reports = append(reports, progress)
+		},
+	}
+
+	//act
+	err := asset.saveRemoteFile(context.Background(), filepath.Join("MyApp", "beta", "1", "MyApp_1.0.1.exe"), filepath.Join(asset.TargetFolder, "update_MyApp_1.0.1.exe"), pinnedFile{})
+
+	//assert
+	assert.NoError(t, err)
+	if assert.True(t, len(reports) >= 2) {
+		assert.Equal(t, Progress{Phase: PhaseDownloading, BytesTotal: int64(len(content))}, reports[0])
+		last := reports[len(reports)-1]
+		assert.Equal(t, PhaseDownloading, last.Phase)
+		assert.Equal(t, int64(len(content)), last.BytesDone)
+		assert.Equal(t, int64(len(content)), last.BytesTotal)
+	}
+}
+
+func Test_progressReader_report(t *testing.T) {
+	//arrange
+	var got Progress
+	start := time.Unix(1600000000, 0)
+	p := &progressReader{
+		onProgress: func(progress Progress) { got = progress },
+		offset:     1000,
+		done:       3000,
+		total:      5000,
+		start:      start,
+	}
+
+	//act
+	p.report(start.Add(2 * time.Second))
+
+	//assert
+	assert.Equal(t, Progress{
+		Phase:      PhaseDownloading,
+		BytesDone:  3000,
+		BytesTotal: 5000,
+		Rate:       1000,
+		ETA:        2 * time.Second,
+	}, got)
+}
+
+func TestAsset_reportPhase(t *testing.T) {
+	//arrange
+	var got []Phase
+	asset := Asset{OnProgress: func(progress Progress) {
+		got = append(got, progress.Phase)
+	}}
+
+	//act
+	asset.reportPhase(PhaseVerifying)
+	Asset{}.reportPhase(PhaseApplying)
+
+	//assert
+	assert.Equal(t, []Phase{PhaseVerifying}, got)
+}
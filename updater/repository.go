@@ -0,0 +1,491 @@
+package updater
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jedisct1/go-minisign"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrThresholdNotReached is returned if repository metadata is not signed by enough keys of its role.
+	ErrThresholdNotReached = errors.New("signature threshold not reached")
+	// ErrTargetNotListed is returned in repository mode for files of the updates source missing in the targets metadata.
+	ErrTargetNotListed = errors.New("file is not listed in the targets metadata")
+	// ErrTargetMismatch is returned in repository mode if the length or a hash of a file differs from the targets metadata.
+	ErrTargetMismatch = errors.New("file does not match the targets metadata")
+)
+
+// The roles of the repository metadata, following The Update Framework (https://theupdateframework.io).
+const (
+	RoleRoot      = "root"
+	RoleTargets   = "targets"
+	RoleSnapshot  = "snapshot"
+	RoleTimestamp = "timestamp"
+)
+
+const (
+	// repositoryDir contains the repository metadata in the updates source.
+	repositoryDir = "tuf"
+	// maxRootRotations limits how many new root versions are loaded by one refresh.
+	maxRootRotations = 32
+)
+
+/*
+The repository metadata is stored next to the assets in the updates source:
+
+UpdateSource/tuf/{Version}.root.json     every version of the root metadata, clients load them one after another
+UpdateSource/tuf/timestamp.json          pinning the version and hashes of the snapshot metadata
+UpdateSource/tuf/{Version}.snapshot.json pinning the version of the targets metadata
+UpdateSource/tuf/{Version}.targets.json  listing length and hashes of every latest.txt, version json and update
+
+Snapshot and targets metadata are read by version, so publishing a new version never mixes old and new metadata.
+*/
+
+// SignedMetadata
+// A file of the repository metadata: the metadata of a role and the Ed25519 signatures of its compact json encoding.
+type SignedMetadata struct {
+	Signed     json.RawMessage     `json:"signed"`
+	Signatures []MetadataSignature `json:"signatures"`
+}
+
+// MetadataSignature
+// A base64 encoded signature of SignedMetadata.Signed, KeyId is formatted like FormatKeyId.
+type MetadataSignature struct {
+	KeyId     string `json:"keyid"`
+	Signature string `json:"sig"`
+}
+
+// RootMetadata
+// Lists the keys trusted for every role and how many of them have to sign its metadata. A new version of the root
+// metadata has to be signed by the threshold of the root role of the previous version and of its own.
+type RootMetadata struct {
+	Type    string    `json:"_type"`
+	Version int64     `json:"version"`
+	Expires time.Time `json:"expires"`
+	// Keys maps key ids to base64 encoded public keys like UpdateFilesPubKey.
+	Keys  map[string]string `json:"keys"`
+	Roles map[string]Role   `json:"roles"`
+}
+
+// Role
+// The key ids allowed to sign the metadata of a role and the number of different keys which have to sign it.
+type Role struct {
+	KeyIds    []string `json:"keyids"`
+	Threshold int      `json:"threshold"`
+}
+
+// TargetsMetadata
+// Lists every file of the updates source clients read in repository mode, keyed by its slash separated path.
+type TargetsMetadata struct {
+	Type    string                `json:"_type"`
+	Version int64                 `json:"version"`
+	Expires time.Time             `json:"expires"`
+	Targets map[string]TargetFile `json:"targets"`
+}
+
+// TargetFile
+// The length and the hex encoded "sha256" and "sha512" hashes of a file. Create it with NewTargetFile.
+type TargetFile struct {
+	Length int64             `json:"length"`
+	Hashes map[string]string `json:"hashes"`
+}
+
+// SnapshotMetadata
+// Pins the version of the targets metadata in Meta["targets.json"].
+type SnapshotMetadata struct {
+	Type    string              `json:"_type"`
+	Version int64               `json:"version"`
+	Expires time.Time           `json:"expires"`
+	Meta    map[string]MetaFile `json:"meta"`
+}
+
+// TimestampMetadata
+// Pins the version of the snapshot metadata in Meta["snapshot.json"]. It is the only file read without version, so it
+// is signed with the shortest expiry.
+type TimestampMetadata struct {
+	Type    string              `json:"_type"`
+	Version int64               `json:"version"`
+	Expires time.Time           `json:"expires"`
+	Meta    map[string]MetaFile `json:"meta"`
+}
+
+// MetaFile
+// The version of a metadata file. Length and Hashes are optional, if Hashes are set the file is checked like a TargetFile.
+type MetaFile struct {
+	Version int64             `json:"version"`
+	Length  int64             `json:"length,omitempty"`
+	Hashes  map[string]string `json:"hashes,omitempty"`
+}
+
+// metadataHeader holds the fields common to the metadata of all roles.
+type metadataHeader struct {
+	Type    string    `json:"_type"`
+	Version int64     `json:"version"`
+	Expires time.Time `json:"expires"`
+}
+
+// RepositoryFile
+// Returns the path of the metadata of role in the updates source, e.g. "tuf/3.snapshot.json", or without version
+// "tuf/timestamp.json".
+func RepositoryFile(role string, version int64) string {
+	if version == 0 {
+		return path.Join(repositoryDir, role+".json")
+	}
+	return path.Join(repositoryDir, strconv.FormatInt(version, 10)+"."+role+".json")
+}
+
+// NewTargetFile
+// Reads r to compute the length and hashes of a file for TargetsMetadata.
+func NewTargetFile(r io.Reader) (target TargetFile, err error) {
+	sha256Hash, sha512Hash := sha256.New(), sha512.New()
+	if target.Length, err = io.Copy(io.MultiWriter(sha256Hash, sha512Hash), r); err != nil {
+		return TargetFile{}, err
+	}
+	target.Hashes = map[string]string{
+		"sha256": hex.EncodeToString(sha256Hash.Sum(nil)),
+		"sha512": hex.EncodeToString(sha512Hash.Sum(nil)),
+	}
+	return target, nil
+}
+
+// matches checks the length and every known hash of the content read from r, which is not read beyond the length.
+func (t TargetFile) matches(r io.Reader) error {
+	actual, err := NewTargetFile(io.LimitReader(r, t.Length+1))
+	if err != nil {
+		return err
+	}
+	if actual.Length != t.Length {
+		return fmt.Errorf("%w: length differs from %d", ErrTargetMismatch, t.Length)
+	}
+	checked := 0
+	for algorithm, hash := range t.Hashes {
+		actualHash, known := actual.Hashes[algorithm]
+		if !known {
+			continue
+		}
+		if !strings.EqualFold(actualHash, hash) {
+			return fmt.Errorf("%w: %s hash differs", ErrTargetMismatch, algorithm)
+		}
+		checked++
+	}
+	if checked == 0 {
+		return fmt.Errorf("%w: no sha256 or sha512 hash", ErrTargetMismatch)
+	}
+	return nil
+}
+
+func (m MetaFile) matches(data []byte) error {
+	if len(m.Hashes) == 0 {
+		return nil
+	}
+	return TargetFile{Length: m.Length, Hashes: m.Hashes}.matches(bytes.NewReader(data))
+}
+
+// Repository
+// The trusted root metadata of an updates source in repository mode and the targets metadata verified by the last
+// refresh. Create it with NewRepository, it is safe for concurrent use by several assets.
+type Repository struct {
+	mutex sync.RWMutex
+	// trustedRoot is the root metadata of NewRepository, every newer version is verified in a chain starting with it.
+	trustedRoot RootMetadata
+	root        RootMetadata
+	// rootChain holds the root metadata versions verified after trustedRoot, root is the last of them.
+	rootChain []json.RawMessage
+	targets   *TargetsMetadata
+}
+
+// repositoryState is persisted in the state file of the asset.
+type repositoryState struct {
+	// Roots are the root metadata versions verified after the root metadata of NewRepository. They are verified again
+	// one after another starting with it, so the state file can not replace the trusted root.
+	Roots []json.RawMessage `json:",omitempty"`
+	// Versions holds the highest verified version of the timestamp, snapshot and targets metadata.
+	Versions map[string]int64
+}
+
+// NewRepository
+// Creates a Repository trusting the root metadata shipped with the application, e.g. an embedded copy of
+// tuf/1.root.json. It has to be signed by the threshold of its own root role. Newer versions are loaded from the updates
+// source.
+func NewRepository(trustedRoot []byte) (*Repository, error) {
+	root, err := parseRoot(trustedRoot, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Repository{trustedRoot: root, root: root}, nil
+}
+
+// Root
+// Returns the root metadata trusted after the last refresh.
+func (r *Repository) Root() RootMetadata {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.root
+}
+
+// refresh loads new versions of the root metadata, then the timestamp, snapshot and targets metadata. Expired metadata
+// and metadata older than recorded in state are rejected with a ReplayError. state is updated with the verified versions.
+func (r *Repository) refresh(ctx context.Context, client Client, state *repositoryState) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	trusted, chain := r.root, r.rootChain
+	persisted, err := verifyRootChain(r.trustedRoot, state.Roots)
+	if err != nil {
+		return fmt.Errorf("invalid root metadata in state file: %w", err)
+	}
+	if persisted.Version > trusted.Version {
+		trusted, chain = persisted, state.Roots
+	}
+	root, chain, err := updateRoot(ctx, client, trusted, chain)
+	if err != nil {
+		return err
+	}
+	if time.Now().After(root.Expires) {
+		return &ReplayError{File: RepositoryFile(RoleRoot, root.Version), Err: ErrFreeze}
+	}
+
+	if state.Versions == nil {
+		state.Versions = map[string]int64{}
+	}
+	// New timestamp or snapshot keys recover from a fast-forward attack, which left versions no one can sign again.
+	if !reflect.DeepEqual(trusted.Roles[RoleTimestamp], root.Roles[RoleTimestamp]) || !reflect.DeepEqual(trusted.Roles[RoleSnapshot], root.Roles[RoleSnapshot]) {
+		delete(state.Versions, RoleTimestamp)
+		delete(state.Versions, RoleSnapshot)
+	}
+
+	var timestamp TimestampMetadata
+	if err = root.loadRole(ctx, client, RoleTimestamp, 0, nil, state, &timestamp); err != nil {
+		return err
+	}
+	snapshotFile, found := timestamp.Meta[RoleSnapshot+".json"]
+	if !found {
+		return fmt.Errorf("%s does not pin %s.json", RepositoryFile(RoleTimestamp, 0), RoleSnapshot)
+	}
+	var snapshot SnapshotMetadata
+	if err = root.loadRole(ctx, client, RoleSnapshot, snapshotFile.Version, &snapshotFile, state, &snapshot); err != nil {
+		return err
+	}
+	targetsFile, found := snapshot.Meta[RoleTargets+".json"]
+	if !found {
+		return fmt.Errorf("%s does not pin %s.json", RepositoryFile(RoleSnapshot, snapshotFile.Version), RoleTargets)
+	}
+	var targets TargetsMetadata
+	if err = root.loadRole(ctx, client, RoleTargets, targetsFile.Version, &targetsFile, state, &targets); err != nil {
+		return err
+	}
+
+	state.Roots = chain
+	r.root, r.rootChain, r.targets = root, chain, &targets
+	return nil
+}
+
+// verifyTarget checks the content read from r against the entry of location in the targets metadata of the last refresh.
+func (r *Repository) verifyTarget(location string, content io.Reader) error {
+	r.mutex.RLock()
+	targets := r.targets
+	r.mutex.RUnlock()
+	if targets == nil {
+		return errors.New("repository metadata is not loaded")
+	}
+	location = filepath.ToSlash(location)
+	target, found := targets.Targets[location]
+	if !found {
+		return fmt.Errorf("%s: %w", location, ErrTargetNotListed)
+	}
+	if err := target.matches(content); err != nil {
+		return fmt.Errorf("%s: %w", location, err)
+	}
+	return nil
+}
+
+// updateRoot loads the versions of the root metadata following root, until the next version does not exist, and
+// appends them to chain.
+func updateRoot(ctx context.Context, client Client, root RootMetadata, chain []json.RawMessage) (RootMetadata, []json.RawMessage, error) {
+	for i := 0; i < maxRootRotations; i++ {
+		location := RepositoryFile(RoleRoot, root.Version+1)
+		data, err := client.readData(ctx, location)
+		if isNotFound(err) {
+			break
+		}
+		if err != nil {
+			return RootMetadata{}, nil, err
+		}
+		newRoot, err := parseRoot(data, &root)
+		if err != nil {
+			return RootMetadata{}, nil, fmt.Errorf("%s: %w", location, err)
+		}
+		if newRoot.Version != root.Version+1 {
+			return RootMetadata{}, nil, fmt.Errorf("%s has version %d", location, newRoot.Version)
+		}
+		root, chain = newRoot, append(chain[:len(chain):len(chain)], data)
+	}
+	return root, chain, nil
+}
+
+// verifyRootChain verifies the root metadata versions of chain one after another, each with the version before it,
+// starting with root. Returns the last version.
+func verifyRootChain(root RootMetadata, chain []json.RawMessage) (RootMetadata, error) {
+	for _, data := range chain {
+		next, err := parseRoot(data, &root)
+		if err != nil {
+			return RootMetadata{}, err
+		}
+		if next.Version != root.Version+1 {
+			return RootMetadata{}, fmt.Errorf("root metadata version %d follows version %d", next.Version, root.Version)
+		}
+		root = next
+	}
+	return root, nil
+}
+
+// parseRoot decodes root metadata, which has to be signed by the threshold of its own root role and, if set, of the
+// root role of trusted.
+func parseRoot(data []byte, trusted *RootMetadata) (root RootMetadata, err error) {
+	var envelope SignedMetadata
+	if err = json.Unmarshal(data, &envelope); err != nil {
+		return RootMetadata{}, fmt.Errorf("invalid root metadata: %v", err)
+	}
+	var unverified RootMetadata
+	if err = json.Unmarshal(envelope.Signed, &unverified); err != nil {
+		return RootMetadata{}, fmt.Errorf("invalid root metadata: %v", err)
+	}
+	if trusted != nil {
+		if _, err = trusted.verifyRole(RoleRoot, data, &RootMetadata{}); err != nil {
+			return RootMetadata{}, err
+		}
+	}
+	if _, err = unverified.verifyRole(RoleRoot, data, &root); err != nil {
+		return RootMetadata{}, err
+	}
+	return root, nil
+}
+
+// loadRole reads the metadata of role in the given version, or without version for version 0, verifies it against the
+// pinned MetaFile of its parent and the keys of the role and checks its version and expiry.
+func (root RootMetadata) loadRole(ctx context.Context, client Client, role string, version int64, pinned *MetaFile, state *repositoryState, metadata interface{}) error {
+	location := RepositoryFile(role, version)
+	data, err := client.readData(ctx, location)
+	if err != nil {
+		return err
+	}
+	if pinned != nil {
+		if err = pinned.matches(data); err != nil {
+			return fmt.Errorf("%s: %w", location, err)
+		}
+	}
+	header, err := root.verifyRole(role, data, metadata)
+	if err != nil {
+		return fmt.Errorf("%s: %w", location, err)
+	}
+	if version != 0 && header.Version != version {
+		return fmt.Errorf("%s has version %d", location, header.Version)
+	}
+	if header.Version < state.Versions[role] {
+		return &ReplayError{File: location, Err: ErrRollback}
+	}
+	if time.Now().After(header.Expires) {
+		return &ReplayError{File: location, Err: ErrFreeze}
+	}
+	state.Versions[role] = header.Version
+	return nil
+}
+
+// verifyRole checks that data is metadata of role signed by the threshold of its keys and decodes it into metadata.
+func (root RootMetadata) verifyRole(role string, data []byte, metadata interface{}) (header metadataHeader, err error) {
+	var envelope SignedMetadata
+	if err = json.Unmarshal(data, &envelope); err != nil {
+		return metadataHeader{}, fmt.Errorf("invalid %s metadata: %v", role, err)
+	}
+	signed := &bytes.Buffer{}
+	if err = json.Compact(signed, envelope.Signed); err != nil {
+		return metadataHeader{}, fmt.Errorf("invalid %s metadata: %v", role, err)
+	}
+	if err = root.checkThreshold(role, signed.Bytes(), envelope.Signatures); err != nil {
+		return metadataHeader{}, err
+	}
+	if err = json.Unmarshal(signed.Bytes(), &header); err != nil {
+		return metadataHeader{}, fmt.Errorf("invalid %s metadata: %v", role, err)
+	}
+	if header.Type != role {
+		return metadataHeader{}, fmt.Errorf("%s metadata has type %q", role, header.Type)
+	}
+	if err = json.Unmarshal(signed.Bytes(), metadata); err != nil {
+		return metadataHeader{}, fmt.Errorf("invalid %s metadata: %v", role, err)
+	}
+	return header, nil
+}
+
+// checkThreshold counts the valid signatures of different keys of role. Signatures of other keys are ignored.
+func (root RootMetadata) checkThreshold(role string, signed []byte, signatures []MetadataSignature) error {
+	r, found := root.Roles[role]
+	if !found || r.Threshold < 1 {
+		return fmt.Errorf("root metadata has no valid %s role", role)
+	}
+	roleKeys := map[string]bool{}
+	for _, keyId := range r.KeyIds {
+		roleKeys[keyId] = true
+	}
+	valid := map[[ed25519.PublicKeySize]byte]bool{}
+	for _, signature := range signatures {
+		if !roleKeys[signature.KeyId] {
+			continue
+		}
+		pub, err := minisign.NewPublicKey(root.Keys[signature.KeyId])
+		if err != nil || FormatKeyId(pub.KeyId) != signature.KeyId {
+			continue
+		}
+		sig, err := base64.StdEncoding.DecodeString(signature.Signature)
+		if err != nil {
+			continue
+		}
+		if ed25519.Verify(pub.PublicKey[:], signed, sig) {
+			valid[pub.PublicKey] = true
+		}
+	}
+	if len(valid) < r.Threshold {
+		return fmt.Errorf("%s: %w, %d of %d keys signed", role, ErrThresholdNotReached, len(valid), r.Threshold)
+	}
+	return nil
+}
+
+// refreshRepository refreshes the Repository and persists the verified metadata versions in the state file.
+func (a Asset) refreshRepository(ctx context.Context) error {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	state, err := a.readState()
+	if err != nil {
+		return err
+	}
+	if state.Repository == nil {
+		state.Repository = &repositoryState{}
+	}
+	if err = a.Repository.refresh(ctx, a.Client, state.Repository); err != nil {
+		return err
+	}
+	return a.writeState(state)
+}
+
+// isNotFound reports whether err is returned for a file missing in the updates source.
+func isNotFound(err error) bool {
+	var httpError *HttpError
+	return errors.Is(err, os.ErrNotExist) || errors.As(err, &httpError) && httpError.StatusCode == http.StatusNotFound
+}
@@ -0,0 +1,282 @@
+package updater
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/Flaque/filet"
+	"github.com/haevg-rz/go-updater/internal/signing"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// testRepository signs the repository metadata of an updates source with one key per role.
+type testRepository struct {
+	t       *testing.T
+	cdn     fstest.MapFS
+	keys    map[string]signing.SecretKey
+	version int64
+}
+
+func newTestRepository(t *testing.T) *testRepository {
+	r := &testRepository{t: t, cdn: newTestCdn(testUpdateFiles("MyApp", "1.0.1")), keys: map[string]signing.SecretKey{}}
+	for _, role := range []string{RoleRoot, RoleTargets, RoleSnapshot, RoleTimestamp} {
+		r.keys[role] = newTestSecretKey(t)
+	}
+	r.addRoot(r.newRoot(1), r.keys[RoleRoot])
+	r.publish(time.Hour)
+	return r
+}
+
+// newRoot trusts the current key of every role with a threshold of 1.
+func (r *testRepository) newRoot(version int64) RootMetadata {
+	root := RootMetadata{Type: RoleRoot, Version: version, Expires: time.Now().Add(time.Hour), Keys: map[string]string{}, Roles: map[string]Role{}}
+	for role, key := range r.keys {
+		keyId := FormatKeyId(key.KeyId)
+		root.Keys[keyId] = key.PublicKey()
+		root.Roles[role] = Role{KeyIds: []string{keyId}, Threshold: 1}
+	}
+	return root
+}
+
+func (r *testRepository) sign(metadata interface{}, keys ...signing.SecretKey) []byte {
+	data, err := signing.SignMetadata(metadata, keys...)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	return data
+}
+
+func (r *testRepository) addRoot(root RootMetadata, keys ...signing.SecretKey) []byte {
+	data := r.sign(root, keys...)
+	r.cdn[RepositoryFile(RoleRoot, root.Version)] = &fstest.MapFile{Data: data}
+	return data
+}
+
+// publish signs targets metadata listing all files of the updates source, the snapshot and the timestamp metadata.
+func (r *testRepository) publish(expires time.Duration) {
+	r.version++
+	targets := TargetsMetadata{Type: RoleTargets, Version: r.version, Expires: time.Now().Add(expires), Targets: map[string]TargetFile{}}
+	for name, file := range r.cdn {
+		if !strings.HasPrefix(name, repositoryDir+"/") {
+			targets.Targets[name], _ = NewTargetFile(bytes.NewReader(file.Data))
+		}
+	}
+	r.cdn[RepositoryFile(RoleTargets, r.version)] = &fstest.MapFile{Data: r.sign(targets, r.keys[RoleTargets])}
+	snapshot := SnapshotMetadata{Type: RoleSnapshot, Version: r.version, Expires: time.Now().Add(expires), Meta: map[string]MetaFile{"targets.json": {Version: r.version}}}
+	snapshotData := r.sign(snapshot, r.keys[RoleSnapshot])
+	r.cdn[RepositoryFile(RoleSnapshot, r.version)] = &fstest.MapFile{Data: snapshotData}
+	snapshotFile, _ := NewTargetFile(bytes.NewReader(snapshotData))
+	timestamp := TimestampMetadata{Type: RoleTimestamp, Version: r.version, Expires: time.Now().Add(expires), Meta: map[string]MetaFile{
+		"snapshot.json": {Version: r.version, Length: snapshotFile.Length, Hashes: snapshotFile.Hashes},
+	}}
+	r.cdn[RepositoryFile(RoleTimestamp, 0)] = &fstest.MapFile{Data: r.sign(timestamp, r.keys[RoleTimestamp])}
+}
+
+func (r *testRepository) newAsset() Asset {
+	repository, err := NewRepository(r.cdn[RepositoryFile(RoleRoot, 1)].Data)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	targetFolder := filet.TmpDir(r.t, "")
+	_ = ioutil.WriteFile(filepath.Join(targetFolder, "MyApp.txt"), []byte("Hello World"), 0644)
+	return Asset{AssetName: "MyApp", AssetVersion: "1.0.0", Channel: "beta", Specs: map[string]string{}, Client: FSClient{FS: r.cdn}, TargetFolder: targetFolder, Repository: repository}
+}
+
+func TestAsset_UpdateRepositoryMode(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	asset := newTestRepository(t).newAsset()
+
+	//act
+	updatedTo, updated, err := asset.Update()
+
+	//assert
+	assert.NoError(t, err)
+	assert.True(t, updated)
+	if assert.NotNil(t, updatedTo) {
+		assert.Equal(t, "1.0.1", updatedTo.Version)
+	}
+	got, _ := ioutil.ReadFile(filepath.Join(asset.TargetFolder, "MyApp.txt"))
+	assert.Equal(t, "Hello Gophers", string(got))
+	state, err := asset.readState()
+	assert.NoError(t, err)
+	if assert.NotNil(t, state.Repository) {
+		assert.Equal(t, map[string]int64{RoleTimestamp: 1, RoleSnapshot: 1, RoleTargets: 1}, state.Repository.Versions)
+	}
+}
+
+func TestAsset_CheckForUpdatesRepositoryMode(t *testing.T) {
+	defer filet.CleanUp(t)
+	tests := []struct {
+		name      string
+		modify    func(r *testRepository)
+		wantErrIs error
+	}{
+		{"valid metadata", func(r *testRepository) {}, nil},
+		{"tampered latest.txt", func(r *testRepository) {
+			r.cdn["MyApp/beta/latest.txt"] = &fstest.MapFile{Data: []byte("2")}
+		}, ErrTargetMismatch},
+		{"file not listed", func(r *testRepository) {
+			targets := TargetsMetadata{Type: RoleTargets, Version: 1, Expires: time.Now().Add(time.Hour), Targets: map[string]TargetFile{}}
+			r.cdn[RepositoryFile(RoleTargets, 1)] = &fstest.MapFile{Data: r.sign(targets, r.keys[RoleTargets])}
+		}, ErrTargetNotListed},
+		{"targets signed by other key", func(r *testRepository) {
+			r.keys[RoleTargets] = newTestSecretKey(t)
+			r.publish(time.Hour)
+		}, ErrThresholdNotReached},
+		{"expired timestamp", func(r *testRepository) {
+			r.publish(-time.Minute)
+		}, ErrFreeze},
+		{"snapshot differs from timestamp", func(r *testRepository) {
+			r.cdn[RepositoryFile(RoleSnapshot, 1)] = &fstest.MapFile{Data: r.sign(SnapshotMetadata{Type: RoleSnapshot, Version: 1, Expires: time.Now().Add(time.Hour), Meta: map[string]MetaFile{"targets.json": {Version: 1}}}, r.keys[RoleSnapshot])}
+		}, ErrTargetMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			//arrange
+			r := newTestRepository(t)
+			tt.modify(r)
+			asset := r.newAsset()
+
+			//act
+			_, updateFound, err := asset.CheckForUpdates()
+
+			//assert
+			if tt.wantErrIs == nil {
+				assert.NoError(t, err)
+				assert.True(t, updateFound)
+				return
+			}
+			assert.True(t, errors.Is(err, tt.wantErrIs), "got %v", err)
+			assert.False(t, updateFound)
+		})
+	}
+}
+
+func TestAsset_CheckForUpdatesRepositoryRollback(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	r := newTestRepository(t)
+	asset := r.newAsset()
+	oldTimestamp := r.cdn[RepositoryFile(RoleTimestamp, 0)]
+	r.publish(time.Hour)
+	_, _, err := asset.CheckForUpdates()
+	assert.NoError(t, err)
+	r.cdn[RepositoryFile(RoleTimestamp, 0)] = oldTimestamp
+
+	//act
+	_, updateFound, err := asset.CheckForUpdates()
+
+	//assert
+	assert.False(t, updateFound)
+	var replayError *ReplayError
+	if assert.True(t, errors.As(err, &replayError), "got %v", err) {
+		assert.Equal(t, ErrRollback, replayError.Err)
+		assert.Equal(t, "tuf/timestamp.json", replayError.File)
+	}
+}
+
+func TestAsset_CheckForUpdatesRepositoryRootRotation(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	r := newTestRepository(t)
+	asset := r.newAsset()
+	oldRootKey := r.keys[RoleRoot]
+	r.keys[RoleRoot], r.keys[RoleTargets] = newTestSecretKey(t), newTestSecretKey(t)
+	r.addRoot(r.newRoot(2), oldRootKey, r.keys[RoleRoot])
+	r.publish(time.Hour)
+
+	//act
+	_, updateFound, err := asset.CheckForUpdates()
+
+	//assert
+	assert.NoError(t, err)
+	assert.True(t, updateFound)
+	assert.Equal(t, int64(2), asset.Repository.Root().Version)
+	state, _ := asset.readState()
+	if assert.Len(t, state.Repository.Roots, 1) {
+		root, err := parseRoot(state.Repository.Roots[0], nil)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), root.Version)
+	}
+}
+
+func TestAsset_CheckForUpdatesRepositoryRootInStateNotSignedByTrustedRoot(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	r := newTestRepository(t)
+	asset := r.newAsset()
+	// root metadata of an attacker who can write the state file, with its own root key
+	attackerKey := newTestSecretKey(t)
+	root := r.newRoot(2)
+	root.Keys[FormatKeyId(attackerKey.KeyId)] = attackerKey.PublicKey()
+	root.Roles[RoleRoot] = Role{KeyIds: []string{FormatKeyId(attackerKey.KeyId)}, Threshold: 1}
+	attackerRoot := r.sign(root, attackerKey)
+	assert.NoError(t, asset.writeState(assetState{Files: map[string]fileState{}, Repository: &repositoryState{Roots: []json.RawMessage{attackerRoot}}}))
+
+	//act
+	_, updateFound, err := asset.CheckForUpdates()
+
+	//assert
+	assert.False(t, updateFound)
+	assert.True(t, errors.Is(err, ErrThresholdNotReached), "got %v", err)
+	assert.Equal(t, int64(1), asset.Repository.Root().Version)
+}
+
+func TestAsset_CheckForUpdatesRepositoryRootNotSignedByPreviousRoot(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	r := newTestRepository(t)
+	asset := r.newAsset()
+	r.keys[RoleRoot], r.keys[RoleTargets] = newTestSecretKey(t), newTestSecretKey(t)
+	r.addRoot(r.newRoot(2), r.keys[RoleRoot])
+	r.publish(time.Hour)
+
+	//act
+	_, updateFound, err := asset.CheckForUpdates()
+
+	//assert
+	assert.False(t, updateFound)
+	assert.True(t, errors.Is(err, ErrThresholdNotReached), "got %v", err)
+	assert.Equal(t, int64(1), asset.Repository.Root().Version)
+}
+
+func TestRootMetadata_checkThreshold(t *testing.T) {
+	//arrange
+	keys := []signing.SecretKey{newTestSecretKey(t), newTestSecretKey(t), newTestSecretKey(t)}
+	root := RootMetadata{Keys: map[string]string{}, Roles: map[string]Role{RoleTargets: {Threshold: 2}}}
+	for _, key := range keys[:2] {
+		root.Keys[FormatKeyId(key.KeyId)] = key.PublicKey()
+		root.Roles[RoleTargets] = Role{KeyIds: append(root.Roles[RoleTargets].KeyIds, FormatKeyId(key.KeyId)), Threshold: 2}
+	}
+	tests := []struct {
+		name    string
+		keys    []signing.SecretKey
+		wantErr bool
+	}{
+		{"threshold reached", keys[:2], false},
+		{"one key", keys[:1], true},
+		{"same key twice", []signing.SecretKey{keys[0], keys[0]}, true},
+		{"key of other role", []signing.SecretKey{keys[0], keys[2]}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := signing.SignMetadata(TargetsMetadata{Type: RoleTargets, Version: 1}, tt.keys...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			//act
+			_, err = root.verifyRole(RoleTargets, data, &TargetsMetadata{})
+
+			//assert
+			assert.Equal(t, tt.wantErr, err != nil, "got %v", err)
+		})
+	}
+}
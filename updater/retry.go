@@ -0,0 +1,215 @@
+package updater
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultBackoffBase = 500 * time.Millisecond
+	defaultBackoffCap  = 30 * time.Second
+)
+
+var defaultRetryableStatusCodes = []int{
+	http.StatusRequestTimeout,
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// RetryPolicy
+// Retries requests of an HttpClient which failed with a network error or a retryable status code. The delay between
+// attempts grows exponentially from BackoffBase up to BackoffCap and is randomized by Jitter. A Retry-After header
+// sent by the server takes precedence, up to BackoffCap. Failing HeaderProviders, untrusted TLS certificates and
+// canceled requests are not retried, neither are attempts whose delay would exceed the deadline of the context.
+type RetryPolicy struct {
+	// MaxAttempts is the number of requests sent including the first one. Values below 2 disable retries.
+	MaxAttempts int
+	// BackoffBase is the delay before the first retry, doubled for every further retry. Defaults to 500ms.
+	BackoffBase time.Duration
+	// BackoffCap is the maximum delay between two attempts, also for Retry-After headers. Defaults to 30s.
+	BackoffCap time.Duration
+	// Jitter is the fraction (0 to 1) by which each delay is randomly increased or decreased.
+	Jitter float64
+	// RetryableStatusCodes defaults to 408, 429, 500, 502, 503 and 504.
+	RetryableStatusCodes []int
+	// OnRetry is called for every failed attempt which is going to be retried.
+	OnRetry func(attempt RetryAttempt)
+
+	clock  clock
+	random func() float64
+}
+
+// RetryAttempt
+// Describes a failed attempt passed to RetryPolicy.OnRetry. Either Err or StatusCode is set.
+type RetryAttempt struct {
+	Attempt    int
+	Url        string
+	StatusCode int
+	Err        error
+	Delay      time.Duration
+}
+
+type clock interface {
+	Now() time.Time
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+type retryClient struct {
+	next   httpClientInterface
+	policy RetryPolicy
+}
+
+func (c retryClient) Do(req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := c.next.Do(req)
+		if attempt >= c.policy.MaxAttempts || req.Context().Err() != nil || !c.policy.isRetryable(resp, err) {
+			return resp, err
+		}
+
+		retry := RetryAttempt{
+			Attempt: attempt,
+			Url:     req.URL.String(),
+			Err:     err,
+			Delay:   c.policy.getDelay(attempt),
+		}
+		if resp != nil {
+			retry.StatusCode = resp.StatusCode
+			if retryAfter, ok := c.policy.getRetryAfter(resp); ok {
+				retry.Delay = retryAfter
+			}
+		}
+		// the real failure is returned instead of waiting into the deadline of the caller
+		if deadline, ok := req.Context().Deadline(); ok && time.Until(deadline) < retry.Delay {
+			return resp, err
+		}
+		if resp != nil {
+			_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxHttpErrorBodyLength))
+			_ = resp.Body.Close()
+		}
+		if c.policy.OnRetry != nil {
+			c.policy.OnRetry(retry)
+		}
+		if err = c.policy.getClock().Sleep(req.Context(), retry.Delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p RetryPolicy) isRetryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return !isPermanentError(err)
+	}
+	statusCodes := p.RetryableStatusCodes
+	if statusCodes == nil {
+		statusCodes = defaultRetryableStatusCodes
+	}
+	for _, statusCode := range statusCodes {
+		if resp.StatusCode == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// isPermanentError detects errors which fail again on every retry: failing HeaderProviders, untrusted TLS certificates
+// and canceled requests.
+func isPermanentError(err error) bool {
+	var headerErr *headerProviderError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var certificateInvalidErr x509.CertificateInvalidError
+	var hostnameErr x509.HostnameError
+	return errors.As(err, &headerErr) ||
+		errors.As(err, &unknownAuthorityErr) ||
+		errors.As(err, &certificateInvalidErr) ||
+		errors.As(err, &hostnameErr) ||
+		errors.Is(err, context.Canceled)
+}
+
+// getDelay returns the randomized exponential backoff after the given failed attempt.
+func (p RetryPolicy) getDelay(attempt int) time.Duration {
+	base, limit := p.BackoffBase, p.getBackoffCap()
+	if base <= 0 {
+		base = defaultBackoffBase
+	}
+	delay := base
+	for i := 1; i < attempt && delay < limit; i++ {
+		delay *= 2
+	}
+	if delay > limit {
+		delay = limit
+	}
+	if p.Jitter > 0 {
+		delay = time.Duration(float64(delay) * (1 + p.Jitter*(2*p.getRandom()-1)))
+	}
+	return delay
+}
+
+// getRetryAfter parses a Retry-After header given in seconds or as HTTP date, limited to BackoffCap.
+func (p RetryPolicy) getRetryAfter(resp *http.Response) (delay time.Duration, ok bool) {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		delay = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		delay = date.Sub(p.getClock().Now())
+	} else {
+		return 0, false
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	if limit := p.getBackoffCap(); delay > limit {
+		delay = limit
+	}
+	return delay, true
+}
+
+func (p RetryPolicy) getBackoffCap() time.Duration {
+	if p.BackoffCap > 0 {
+		return p.BackoffCap
+	}
+	return defaultBackoffCap
+}
+
+func (p RetryPolicy) getClock() clock {
+	if p.clock != nil {
+		return p.clock
+	}
+	return realClock{}
+}
+
+func (p RetryPolicy) getRandom() float64 {
+	if p.random != nil {
+		return p.random()
+	}
+	return rand.Float64()
+}
@@ -0,0 +1,279 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func (f *fakeClock) Now() time.Time {
+	return f.now
+}
+
+func (f *fakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	f.sleeps = append(f.sleeps, d)
+	f.now = f.now.Add(d)
+	return ctx.Err()
+}
+
+func newFlakyServer(responses ...func(rw http.ResponseWriter)) (server *httptest.Server, requests *int) {
+	requests = new(int)
+	server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		respond := responses[len(responses)-1]
+		if *requests < len(responses) {
+			respond = responses[*requests]
+		}
+		*requests++
+		respond(rw)
+	}))
+	return server, requests
+}
+
+func respondStatus(statusCode int, header ...string) func(rw http.ResponseWriter) {
+	return func(rw http.ResponseWriter) {
+		for i := 0; i+1 < len(header); i += 2 {
+			rw.Header().Set(header[i], header[i+1])
+		}
+		rw.WriteHeader(statusCode)
+		_, _ = rw.Write([]byte(http.StatusText(statusCode)))
+	}
+}
+
+func TestHttpClientRetriesWithBackoff(t *testing.T) {
+	//arrange
+	server, requests := newFlakyServer(
+		respondStatus(http.StatusServiceUnavailable),
+		respondStatus(http.StatusBadGateway),
+		respondStatus(http.StatusOK),
+	)
+	defer server.Close()
+	clock := &fakeClock{now: time.Unix(1600000000, 0)}
+	var attempts []RetryAttempt
+	client := HttpClient{
+		CdnBaseUrl: server.URL,
+		Client:     server.Client(),
+		Retry: &RetryPolicy{
+			MaxAttempts: 5,
+			BackoffBase: time.Second,
+			BackoffCap:  time.Minute,
+			Jitter:      0.5,
+			OnRetry: func(attempt RetryAttempt) {
+				attempts = append(attempts, attempt)
+			},
+			clock:  clock,
+			random: func() float64 { return 1 },
+		},
+	}
+
+	//act
+	got, err := client.readData(context.Background(), "MyApp/beta/latest.txt")
+
+	//assert
+	assert.NoError(t, err)
+	assert.Equal(t, "OK", string(got))
+	assert.Equal(t, 3, *requests)
+	assert.Equal(t, []time.Duration{1500 * time.Millisecond, 3 * time.Second}, clock.sleeps)
+	if assert.Len(t, attempts, 2) {
+		assert.Equal(t, RetryAttempt{Attempt: 1, Url: server.URL + "/MyApp/beta/latest.txt", StatusCode: http.StatusServiceUnavailable, Delay: 1500 * time.Millisecond}, attempts[0])
+		assert.Equal(t, http.StatusBadGateway, attempts[1].StatusCode)
+	}
+}
+
+func TestHttpClientRetryGivesUp(t *testing.T) {
+	//arrange
+	server, requests := newFlakyServer(respondStatus(http.StatusServiceUnavailable))
+	defer server.Close()
+	clock := &fakeClock{}
+	client := HttpClient{
+		CdnBaseUrl: server.URL,
+		Client:     server.Client(),
+		Retry:      &RetryPolicy{MaxAttempts: 3, clock: clock},
+	}
+
+	//act
+	_, err := client.readData(context.Background(), "MyApp/beta/latest.txt")
+
+	//assert
+	var httpErr *HttpError
+	if assert.True(t, errors.As(err, &httpErr)) {
+		assert.Equal(t, http.StatusServiceUnavailable, httpErr.StatusCode)
+	}
+	assert.Equal(t, 3, *requests)
+	assert.Equal(t, []time.Duration{defaultBackoffBase, 2 * defaultBackoffBase}, clock.sleeps)
+}
+
+func TestHttpClientRetryHonoursRetryAfter(t *testing.T) {
+	//arrange
+	clock := &fakeClock{now: time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)}
+	server, requests := newFlakyServer(
+		respondStatus(http.StatusTooManyRequests, "Retry-After", "7"),
+		respondStatus(http.StatusServiceUnavailable, "Retry-After", "Mon, 01 Mar 2021 12:01:07 GMT"),
+		respondStatus(http.StatusOK),
+	)
+	defer server.Close()
+	client := HttpClient{
+		CdnBaseUrl: server.URL,
+		Client:     server.Client(),
+		Retry:      &RetryPolicy{MaxAttempts: 3, BackoffCap: 2 * time.Minute, clock: clock},
+	}
+
+	//act
+	_, err := client.readData(context.Background(), "MyApp/beta/latest.txt")
+
+	//assert
+	assert.NoError(t, err)
+	assert.Equal(t, 3, *requests)
+	assert.Equal(t, []time.Duration{7 * time.Second, time.Minute}, clock.sleeps)
+}
+
+func TestHttpClientRetryAfterIsCapped(t *testing.T) {
+	//arrange
+	server, requests := newFlakyServer(
+		respondStatus(http.StatusServiceUnavailable, "Retry-After", "3600"),
+		respondStatus(http.StatusOK),
+	)
+	defer server.Close()
+	clock := &fakeClock{}
+	client := HttpClient{
+		CdnBaseUrl: server.URL,
+		Client:     server.Client(),
+		Retry:      &RetryPolicy{MaxAttempts: 2, BackoffCap: 5 * time.Second, clock: clock},
+	}
+
+	//act
+	_, err := client.readData(context.Background(), "MyApp/beta/latest.txt")
+
+	//assert
+	assert.NoError(t, err)
+	assert.Equal(t, 2, *requests)
+	assert.Equal(t, []time.Duration{5 * time.Second}, clock.sleeps)
+}
+
+func TestHttpClientRetryAfterBeyondDeadline(t *testing.T) {
+	//arrange
+	server, requests := newFlakyServer(respondStatus(http.StatusServiceUnavailable, "Retry-After", "30"))
+	defer server.Close()
+	clock := &fakeClock{}
+	client := HttpClient{
+		CdnBaseUrl: server.URL,
+		Client:     server.Client(),
+		Retry:      &RetryPolicy{MaxAttempts: 3, clock: clock},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	//act
+	_, err := client.readData(ctx, "MyApp/beta/latest.txt")
+
+	//assert
+	var httpErr *HttpError
+	if assert.True(t, errors.As(err, &httpErr), "got %v", err) {
+		assert.Equal(t, http.StatusServiceUnavailable, httpErr.StatusCode)
+	}
+	assert.Equal(t, 1, *requests)
+	assert.Empty(t, clock.sleeps)
+}
+
+func TestHttpClientTimeoutPerAttempt(t *testing.T) {
+	//arrange
+	slow := func(statusCode int) func(rw http.ResponseWriter) {
+		return func(rw http.ResponseWriter) {
+			time.Sleep(60 * time.Millisecond)
+			respondStatus(statusCode)(rw)
+		}
+	}
+	server, requests := newFlakyServer(slow(http.StatusServiceUnavailable), slow(http.StatusServiceUnavailable), slow(http.StatusOK))
+	defer server.Close()
+	client := HttpClient{
+		CdnBaseUrl: server.URL,
+		Client:     server.Client(),
+		Timeout:    150 * time.Millisecond,
+		Retry:      &RetryPolicy{MaxAttempts: 3, clock: &fakeClock{}},
+	}
+
+	//act
+	got, err := client.readData(context.Background(), "MyApp/beta/latest.txt")
+
+	//assert
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusText(http.StatusOK), string(got))
+	assert.Equal(t, 3, *requests)
+}
+
+func TestHttpClientDoesNotRetryPermanentErrors(t *testing.T) {
+	server, requests := newFlakyServer(respondStatus(http.StatusOK))
+	defer server.Close()
+	tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+	defer tlsServer.Close()
+	errProvider := errors.New("token expired")
+	tests := []struct {
+		name   string
+		client HttpClient
+	}{
+		{"header provider fails", HttpClient{CdnBaseUrl: server.URL, Client: server.Client(), HeaderProvider: func(req *http.Request) error { return errProvider }}},
+		{"untrusted certificate", HttpClient{CdnBaseUrl: tlsServer.URL}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			//arrange
+			clock := &fakeClock{}
+			tt.client.Retry = &RetryPolicy{MaxAttempts: 3, clock: clock}
+
+			//act
+			_, err := tt.client.readData(context.Background(), "MyApp/beta/latest.txt")
+
+			//assert
+			assert.Error(t, err)
+			assert.Empty(t, clock.sleeps)
+		})
+	}
+	assert.Equal(t, 0, *requests)
+}
+
+func TestHttpClientDoesNotRetryClientErrors(t *testing.T) {
+	//arrange
+	server, requests := newFlakyServer(respondStatus(http.StatusNotFound))
+	defer server.Close()
+	client := HttpClient{
+		CdnBaseUrl: server.URL,
+		Client:     server.Client(),
+		Retry:      &RetryPolicy{MaxAttempts: 3, clock: &fakeClock{}},
+	}
+
+	//act
+	_, err := client.readData(context.Background(), "MyApp/beta/latest.txt")
+
+	//assert
+	assert.Error(t, err)
+	assert.Equal(t, 1, *requests)
+}
+
+func TestRetryPolicy_getDelay(t *testing.T) {
+	policy := RetryPolicy{BackoffBase: time.Second, BackoffCap: 5 * time.Second}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{40, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, policy.getDelay(tt.attempt))
+	}
+
+	policy.Jitter = 0.2
+	policy.random = func() float64 { return 0 }
+	assert.Equal(t, 800*time.Millisecond, policy.getDelay(1))
+}
@@ -0,0 +1,283 @@
+package updater
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// UnsignedPayload can be passed to S3Client.SignRequest instead of the hash of a request body.
+const UnsignedPayload = "UNSIGNED-PAYLOAD"
+
+// EmptyPayloadHash is the hex encoded SHA-256 hash of an empty body, e.g. of GET requests passed to S3Client.SignRequest.
+const EmptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+const (
+	s3Service       = "s3"
+	s3DefaultRegion = "us-east-1"
+	sigV4Algorithm  = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat = "20060102T150405Z"
+	sigV4DateFormat = "20060102"
+)
+
+// S3Client
+// A Client reading the updates tree from a bucket of an S3 compatible object storage like MinIO or AWS S3. Requests
+// are signed with AWS Signature Version 4. Credentials and region not set explicitly are taken from the environment
+// variables AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN and AWS_REGION.
+type S3Client struct {
+	// Endpoint of the storage, e.g. "https://minio.company.local:9000" or "https://s3.eu-central-1.amazonaws.com".
+	Endpoint string
+	// Region defaults to AWS_REGION, AWS_DEFAULT_REGION or "us-east-1".
+	Region string
+	Bucket string
+	// Prefix is prepended to the key of every file, e.g. "updates" if the tree is stored at "updates/MyApp/...".
+	Prefix string
+	// VirtualHostedStyle addresses the bucket as subdomain of Endpoint instead of the first segment of the path.
+	VirtualHostedStyle bool
+
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// Http configures timeout, transport, retries and caching of the requests. CdnBaseUrl is set by the S3Client,
+	// a HeaderProvider is called before the request is signed.
+	Http HttpClient
+
+	now func() time.Time
+}
+
+func (s S3Client) readData(ctx context.Context, location string) ([]byte, error) {
+	client, err := s.getHttpClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.readData(ctx, location)
+}
+
+func (s S3Client) openData(ctx context.Context, location string) (io.ReadCloser, error) {
+	client, err := s.getHttpClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.openData(ctx, location)
+}
+
+func (s S3Client) openDataRange(ctx context.Context, location string, offset int64, validator string) (io.ReadCloser, remoteFileInfo, error) {
+	client, err := s.getHttpClient()
+	if err != nil {
+		return nil, remoteFileInfo{}, err
+	}
+	return client.openDataRange(ctx, location, offset, validator)
+}
+
+// getHttpClient returns an HttpClient reading from the bucket, which signs every request.
+func (s S3Client) getHttpClient() (HttpClient, error) {
+	bucketUrl, err := s.GetBucketUrl()
+	if err != nil {
+		return HttpClient{}, err
+	}
+	client := s.Http
+	client.CdnBaseUrl = bucketUrl
+	headerProvider := s.Http.HeaderProvider
+	client.HeaderProvider = func(req *http.Request) error {
+		if headerProvider != nil {
+			if err := headerProvider(req); err != nil {
+				return err
+			}
+		}
+		return s.SignRequest(req, EmptyPayloadHash)
+	}
+	return client, nil
+}
+
+// GetBucketUrl
+// Returns the URL of the Prefix in the Bucket, depending on VirtualHostedStyle.
+func (s S3Client) GetBucketUrl() (bucketUrl string, err error) {
+	if s.Bucket == "" {
+		return "", errors.New("no S3 bucket configured")
+	}
+	u, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("invalid S3 endpoint %q", s.Endpoint)
+	}
+	if s.VirtualHostedStyle {
+		u.Host = s.Bucket + "." + u.Host
+		return getTargetUrl(u.String(), s.Prefix)
+	}
+	return getTargetUrl(u.String(), s.Bucket+"/"+s.Prefix)
+}
+
+// SignRequest
+// Signs req with AWS Signature Version 4. payloadHash is the hex encoded SHA-256 hash of the body or UnsignedPayload.
+// The host, Range and all X-Amz-* headers are signed.
+func (s S3Client) SignRequest(req *http.Request, payloadHash string) error {
+	accessKeyId, secretAccessKey, sessionToken := s.getCredentials()
+	if accessKeyId == "" || secretAccessKey == "" {
+		return errors.New("no S3 credentials, set AccessKeyId and SecretAccessKey or AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	signer := sigV4Signer{
+		accessKeyId:     accessKeyId,
+		secretAccessKey: secretAccessKey,
+		sessionToken:    sessionToken,
+		region:          s.getRegion(),
+		service:         s3Service,
+	}
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	signer.sign(req, payloadHash, now().UTC())
+	return nil
+}
+
+func (s S3Client) getCredentials() (accessKeyId string, secretAccessKey string, sessionToken string) {
+	if s.AccessKeyId != "" || s.SecretAccessKey != "" {
+		return s.AccessKeyId, s.SecretAccessKey, s.SessionToken
+	}
+	return os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"), os.Getenv("AWS_SESSION_TOKEN")
+}
+
+func (s S3Client) getRegion() string {
+	for _, region := range []string{s.Region, os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION")} {
+		if region != "" {
+			return region
+		}
+	}
+	return s3DefaultRegion
+}
+
+type sigV4Signer struct {
+	accessKeyId     string
+	secretAccessKey string
+	sessionToken    string
+	region          string
+	service         string
+}
+
+// sign adds the X-Amz-Date, X-Amz-Content-Sha256, X-Amz-Security-Token and Authorization headers to req.
+func (s sigV4Signer) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format(sigV4TimeFormat)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	canonicalHeaders, signedHeaders := getCanonicalHeaders(req)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		getCanonicalUri(req.URL),
+		getCanonicalQuery(req.URL),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{now.Format(sigV4DateFormat), s.region, s.service, "aws4_request"}, "/")
+	canonicalRequestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{sigV4Algorithm, amzDate, scope, hex.EncodeToString(canonicalRequestHash[:])}, "\n")
+
+	key := hmacSha256([]byte("AWS4"+s.secretAccessKey), now.Format(sigV4DateFormat))
+	key = hmacSha256(key, s.region)
+	key = hmacSha256(key, s.service)
+	key = hmacSha256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSha256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, s.accessKeyId, scope, signedHeaders, signature))
+}
+
+func getCanonicalHeaders(req *http.Request) (canonicalHeaders string, signedHeaders string) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name == "range" || strings.HasPrefix(name, "x-amz-") {
+			trimmed := make([]string, 0, len(values))
+			for _, value := range values {
+				trimmed = append(trimmed, strings.Join(strings.Fields(value), " "))
+			}
+			headers[name] = strings.Join(trimmed, ",")
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var builder strings.Builder
+	for _, name := range names {
+		builder.WriteString(name + ":" + headers[name] + "\n")
+	}
+	return builder.String(), strings.Join(names, ";")
+}
+
+// getCanonicalUri encodes every segment of the path once, as S3 expects it.
+func getCanonicalUri(u *url.URL) string {
+	path := u.EscapedPath()
+	if unescaped, err := url.PathUnescape(path); err == nil {
+		path = unescaped
+	}
+	if path == "" {
+		return "/"
+	}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = sigV4Escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func getCanonicalQuery(u *url.URL) string {
+	query := u.Query()
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, sigV4Escape(key)+"="+sigV4Escape(value))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// sigV4Escape percent-encodes everything except the unreserved characters of RFC 3986.
+func sigV4Escape(s string) string {
+	var builder strings.Builder
+	for _, b := range []byte(s) {
+		if b >= 'A' && b <= 'Z' || b >= 'a' && b <= 'z' || b >= '0' && b <= '9' || b == '-' || b == '_' || b == '.' || b == '~' {
+			builder.WriteByte(b)
+		} else {
+			builder.WriteString(fmt.Sprintf("%%%02X", b))
+		}
+	}
+	return builder.String()
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
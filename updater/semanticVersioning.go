@@ -2,44 +2,186 @@ package updater
 
 import (
 	"errors"
+	"fmt"
+	"strconv"
 	"strings"
 )
 
-func getSemanticVersioningParts(version string) (major string, minor string, patch string, err error) {
-	major = "0"
-	minor = "0"
-	patch = "0"
-	parts := strings.Split(version, ".")
+// Version
+// A semantic version as specified by SemVer 2.0 (https://semver.org). Build metadata is kept but ignored for precedence.
+type Version struct {
+	Major      uint64
+	Minor      uint64
+	Patch      uint64
+	Prerelease []string
+	Build      []string
+}
+
+// ParseVersion
+// Parses a semantic versioning string like "1.2.3", "v1.2.3", "1.2.3-rc.1" or "1.2.3+build5".
+func ParseVersion(version string) (v Version, err error) {
+	s := strings.TrimPrefix(strings.TrimSpace(version), "v")
+
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		if v.Build, err = splitIdentifiers(s[i+1:], false); err != nil {
+			return Version{}, fmt.Errorf("invalid Version %q: %v", version, err)
+		}
+		s = s[:i]
+	}
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		if v.Prerelease, err = splitIdentifiers(s[i+1:], true); err != nil {
+			return Version{}, fmt.Errorf("invalid Version %q: %v", version, err)
+		}
+		s = s[:i]
+	}
+
+	parts := strings.Split(s, ".")
 	if len(parts) != 3 {
-		return "", "", "", errors.New("invalid Version")
+		return Version{}, fmt.Errorf("invalid Version %q", version)
 	}
-	major = parts[0]
-	minor = parts[1]
-	patch = parts[2]
-	return major, minor, patch, nil
+	numbers := make([]uint64, 3)
+	for i, part := range parts {
+		if numbers[i], err = parseNumericIdentifier(part); err != nil {
+			return Version{}, fmt.Errorf("invalid Version %q: %v", version, err)
+		}
+	}
+	v.Major, v.Minor, v.Patch = numbers[0], numbers[1], numbers[2]
+	return v, nil
 }
 
-func isUpdateNewerThanCurrent(currentVersion string, updateVersion string) (updateIsNewer bool, err error) {
-	currentMajor, currentMinor, currentPatch, err := getSemanticVersioningParts(currentVersion)
-	if err != nil {
-		return false, err
+// String
+// Returns the version in its canonical form without "v" prefix.
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.Prerelease) > 0 {
+		s += "-" + strings.Join(v.Prerelease, ".")
+	}
+	if len(v.Build) > 0 {
+		s += "+" + strings.Join(v.Build, ".")
 	}
+	return s
+}
 
-	updateMajor, updateMinor, updatePatch, err := getSemanticVersioningParts(updateVersion)
-	if err != nil {
-		return false, err
+// IsPrerelease
+// Reports whether the version carries pre-release identifiers, e.g. "2.0.0-beta.3".
+func (v Version) IsPrerelease() bool {
+	return len(v.Prerelease) > 0
+}
+
+// Compare
+// Returns -1, 0 or +1 depending on whether v has a lower, equal or higher precedence than other.
+func (v Version) Compare(other Version) int {
+	if c := compareUint(v.Major, other.Major); c != 0 {
+		return c
+	}
+	if c := compareUint(v.Minor, other.Minor); c != 0 {
+		return c
+	}
+	if c := compareUint(v.Patch, other.Patch); c != 0 {
+		return c
+	}
+	return comparePrerelease(v.Prerelease, other.Prerelease)
+}
+
+// LessThan
+// Reports whether v has a lower precedence than other.
+func (v Version) LessThan(other Version) bool {
+	return v.Compare(other) < 0
+}
+
+func splitIdentifiers(s string, isPrerelease bool) (identifiers []string, err error) {
+	identifiers = strings.Split(s, ".")
+	for _, identifier := range identifiers {
+		if identifier == "" {
+			return nil, errors.New("empty identifier")
+		}
+		for _, r := range identifier {
+			if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '-') {
+				return nil, fmt.Errorf("invalid character %q in identifier %q", r, identifier)
+			}
+		}
+		if isPrerelease && isNumeric(identifier) && len(identifier) > 1 && identifier[0] == '0' {
+			return nil, fmt.Errorf("numeric identifier %q has a leading zero", identifier)
+		}
+	}
+	return identifiers, nil
+}
+
+func parseNumericIdentifier(s string) (n uint64, err error) {
+	if !isNumeric(s) {
+		return 0, fmt.Errorf("%q is not a number", s)
+	}
+	if len(s) > 1 && s[0] == '0' {
+		return 0, fmt.Errorf("%q has a leading zero", s)
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func compareUint(a uint64, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
 	}
+	return 0
+}
 
-	if updateMajor > currentMajor {
-		return true, nil
+// comparePrerelease follows SemVer 2.0 rule 11: a version without pre-release has a higher precedence, numeric
+// identifiers are compared numerically and have a lower precedence than alphanumeric ones.
+func comparePrerelease(a []string, b []string) int {
+	if len(a) == 0 && len(b) == 0 {
+		return 0
+	}
+	if len(a) == 0 {
+		return 1
 	}
+	if len(b) == 0 {
+		return -1
+	}
+	for i := 0; i < len(a) && i < len(b); i++ {
+		aNumeric, bNumeric := isNumeric(a[i]), isNumeric(b[i])
+		switch {
+		case aNumeric && bNumeric:
+			aNumber, _ := strconv.ParseUint(a[i], 10, 64)
+			bNumber, _ := strconv.ParseUint(b[i], 10, 64)
+			if c := compareUint(aNumber, bNumber); c != 0 {
+				return c
+			}
+		case aNumeric:
+			return -1
+		case bNumeric:
+			return 1
+		default:
+			if c := strings.Compare(a[i], b[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return compareUint(uint64(len(a)), uint64(len(b)))
+}
 
-	if updateMajor == currentMajor && updateMinor > currentMinor {
-		return true, nil
+func isUpdateNewerThanCurrent(currentVersion string, updateVersion string) (updateIsNewer bool, err error) {
+	current, err := ParseVersion(currentVersion)
+	if err != nil {
+		return false, err
 	}
 
-	if updateMajor == currentMajor && updateMinor == currentMinor && updatePatch > currentPatch {
-		return true, nil
+	update, err := ParseVersion(updateVersion)
+	if err != nil {
+		return false, err
 	}
-	return false, nil
+	return current.LessThan(update), nil
 }
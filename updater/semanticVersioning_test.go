@@ -1,41 +1,92 @@
 package updater
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+)
 
-func Test_getSemanticVersioningParts(t *testing.T) {
+func Test_ParseVersion(t *testing.T) {
 	type args struct {
 		version string
 	}
 	tests := []struct {
-		name      string
-		args      args
-		wantMajor string
-		wantMinor string
-		wantPatch string
-		wantErr   bool
+		name    string
+		args    args
+		want    Version
+		wantErr bool
 	}{
-		{"default successful", args{version: "1.2.3"}, "1", "2", "3", false}, {"invalid version", args{version: "v0"}, "", "", "", true},
+		{"default successful", args{version: "1.2.3"}, Version{Major: 1, Minor: 2, Patch: 3}, false},
+		{"invalid version", args{version: "v0"}, Version{}, true},
+		{"multi digit parts", args{version: "10.20.30"}, Version{Major: 10, Minor: 20, Patch: 30}, false},
+		{"v prefix", args{version: "v1.2.3"}, Version{Major: 1, Minor: 2, Patch: 3}, false},
+		{"surrounding whitespace", args{version: " 1.2.3\n"}, Version{Major: 1, Minor: 2, Patch: 3}, false},
+		{"pre-release", args{version: "1.2.3-rc.1"}, Version{Major: 1, Minor: 2, Patch: 3, Prerelease: []string{"rc", "1"}}, false},
+		{"build metadata", args{version: "v1.2.3+build5"}, Version{Major: 1, Minor: 2, Patch: 3, Build: []string{"build5"}}, false},
+		{"pre-release and build metadata", args{version: "1.0.0-beta.3+exp.sha.5114f85"}, Version{Major: 1, Prerelease: []string{"beta", "3"}, Build: []string{"exp", "sha", "5114f85"}}, false},
+		{"leading zero", args{version: "01.2.3"}, Version{}, true},
+		{"leading zero in numeric pre-release", args{version: "1.2.3-rc.01"}, Version{}, true},
+		{"empty pre-release identifier", args{version: "1.2.3-rc..1"}, Version{}, true},
+		{"invalid character", args{version: "1.2.3-rc_1"}, Version{}, true},
+		{"not a number", args{version: "1.x.3"}, Version{}, true},
+		{"four parts", args{version: "1.2.3.4"}, Version{}, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotMajor, gotMinor, gotPatch, err := getSemanticVersioningParts(tt.args.version)
+			got, err := ParseVersion(tt.args.version)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("getSemanticVersioningParts() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("ParseVersion() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if gotMajor != tt.wantMajor {
-				t.Errorf("getSemanticVersioningParts() gotMajor = %v, want %v", gotMajor, tt.wantMajor)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseVersion() got = %v, want %v", got, tt.want)
 			}
-			if gotMinor != tt.wantMinor {
-				t.Errorf("getSemanticVersioningParts() gotMinor = %v, want %v", gotMinor, tt.wantMinor)
+		})
+	}
+}
+
+func TestVersion_Compare(t *testing.T) {
+	tests := []struct {
+		name  string
+		left  string
+		right string
+		want  int
+	}{
+		{"equal", "1.2.3", "1.2.3", 0},
+		{"numeric major", "9.0.0", "10.0.0", -1},
+		{"numeric minor", "1.10.0", "1.9.0", 1},
+		{"numeric patch", "1.0.9", "1.0.10", -1},
+		{"pre-release is lower than release", "1.0.0-alpha", "1.0.0", -1},
+		{"shorter pre-release is lower", "1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"numeric is lower than alphanumeric", "1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"alphanumeric compared lexically", "1.0.0-beta", "1.0.0-alpha.beta", 1},
+		{"numeric pre-release compared numerically", "1.0.0-beta.11", "1.0.0-beta.2", 1},
+		{"rc is higher than beta", "1.0.0-rc.1", "1.0.0-beta.11", 1},
+		{"build metadata is ignored", "1.0.0+build1", "v1.0.0+build2", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			left, err := ParseVersion(tt.left)
+			if err != nil {
+				t.Fatal(err)
+			}
+			right, err := ParseVersion(tt.right)
+			if err != nil {
+				t.Fatal(err)
 			}
-			if gotPatch != tt.wantPatch {
-				t.Errorf("getSemanticVersioningParts() gotPatch = %v, want %v", gotPatch, tt.wantPatch)
+			if got := left.Compare(right); got != tt.want {
+				t.Errorf("Compare() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
+func TestVersion_String(t *testing.T) {
+	v, _ := ParseVersion("v1.0.0-rc.1+build.5")
+	if got := v.String(); got != "1.0.0-rc.1+build.5" {
+		t.Errorf("String() = %v, want %v", got, "1.0.0-rc.1+build.5")
+	}
+}
+
 func Test_isUpdateNewerThanCurrent(t *testing.T) {
 	type args struct {
 		currentVersion string
@@ -92,6 +143,42 @@ func Test_isUpdateNewerThanCurrent(t *testing.T) {
 			wantUpdateIsNewer: true,
 			wantErr:           false,
 		},
+		{
+			name: "update major is numerically greater",
+			args: args{
+				currentVersion: "9.0.0",
+				updateVersion:  "10.0.0",
+			},
+			wantUpdateIsNewer: true,
+			wantErr:           false,
+		},
+		{
+			name: "update major is numerically smaller",
+			args: args{
+				currentVersion: "10.0.0",
+				updateVersion:  "9.0.0",
+			},
+			wantUpdateIsNewer: false,
+			wantErr:           false,
+		},
+		{
+			name: "release is newer than its pre-release",
+			args: args{
+				currentVersion: "2.0.0-rc.1",
+				updateVersion:  "v2.0.0",
+			},
+			wantUpdateIsNewer: true,
+			wantErr:           false,
+		},
+		{
+			name: "pre-release is not newer than its release",
+			args: args{
+				currentVersion: "2.0.0",
+				updateVersion:  "2.0.0-rc.1",
+			},
+			wantUpdateIsNewer: false,
+			wantErr:           false,
+		},
 		{
 			name: "update is not newer",
 			args: args{
@@ -1,36 +1,199 @@
 package updater
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/jedisct1/go-minisign"
+	"golang.org/x/crypto/blake2b"
+	"io"
 	"io/ioutil"
+	"log"
+	"os"
 )
 
 var UpdateFilesPubKey string
 
-func (a Asset) isSignatureValid(fileName string, sigPath string) (sigValid bool, err error) {
-	pub, err := minisign.NewPublicKey(UpdateFilesPubKey)
+var (
+	// ErrNoPublicKey is returned if an asset without KeyRing verifies a signature, but UpdateFilesPubKey is not set or
+	// invalid.
+	ErrNoPublicKey = errors.New("no public key to verify signatures, set UpdateFilesPubKey or KeyRing")
+	// ErrSignatureMissing is returned if the updates source has no signature for a file.
+	ErrSignatureMissing = errors.New("signature missing")
+)
+
+const signatureSuffix = ".minisig"
+
+var (
+	// legacySignatureAlgorithm signs the file itself, which has to be read completely for verification.
+	legacySignatureAlgorithm = [2]byte{'E', 'd'}
+	// prehashedSignatureAlgorithm signs the BLAKE2b-512 hash of the file, which can be computed over a stream.
+	prehashedSignatureAlgorithm = [2]byte{'E', 'D'}
+)
+
+// VerificationError is returned by Update and SelfUpdate if a downloaded update is rejected. Err wraps the reason,
+// like ErrNoPublicKey, ErrSignatureMissing, ErrSignatureInvalid, ErrTargetMismatch or ErrHashMismatch.
+type VerificationError struct {
+	AssetName string
+	Version   string
+	Path      string
+	Err       error
+}
+
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("verification of %s %s (%s) failed: %v", e.AssetName, e.Version, e.Path, e.Err)
+}
+
+func (e *VerificationError) Unwrap() error {
+	return e.Err
+}
+
+// isVerificationFailure detects errors of rejected signatures, metadata, repository targets and downloads differing
+// from their pinned size and hashes, in contrast to I/O, network and context errors.
+func isVerificationFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	var keyErr *KeyError
+	var replayErr *ReplayError
+	if errors.As(err, &keyErr) || errors.As(err, &replayErr) {
+		return true
+	}
+	for _, target := range []error{ErrNoPublicKey, ErrSignatureMissing, ErrSignatureInvalid, ErrMetadataMismatch,
+		ErrThresholdNotReached, ErrTargetNotListed, ErrTargetMismatch, ErrSizeMismatch, ErrHashMismatch} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// verificationFailed removes the rejected download, logs the rejection and reports it to OnVerificationFailed.
+func (a Asset) verificationFailed(update *UpdateInfo, localUpdateFile string, reason error) error {
+	err := &VerificationError{AssetName: a.AssetName, Version: update.Version, Path: update.Path, Err: reason}
+	removeUnverifiedUpdate(localUpdateFile)
+	log.Println("rejected update:", err)
+	if a.OnVerificationFailed != nil {
+		a.OnVerificationFailed(err)
+	}
+	return err
+}
+
+// removeUnverifiedUpdate removes a download which was not verified, so it is never applied later.
+func removeUnverifiedUpdate(localUpdateFile string) {
+	if err := os.Remove(localUpdateFile); err != nil && !os.IsNotExist(err) {
+		log.Println("could not remove unverified update", localUpdateFile+":", err)
+	}
+}
+
+// verifyUpdateFile verifies the downloaded update against the targets metadata in repository mode, otherwise its
+// minisign signature. An error is returned for every update which is not verified.
+func (a Asset) verifyUpdateFile(ctx context.Context, fileName string, update *UpdateInfo) error {
+	if a.Repository == nil {
+		return a.verifyFileSignature(ctx, fileName, a.getCdnSigPath(update.Path), a.getSignedFile(update.Path, update.Version))
+	}
+	file, err := os.Open(fileName)
 	if err != nil {
-		return
+		return err
 	}
-	file, err := ioutil.ReadFile(fileName)
+	defer file.Close()
+	return a.Repository.verifyTarget(update.Path, file)
+}
+
+// verifyFileSignature verifies the signature at sigPath of the downloaded file. With RequireSignedMetadata its trusted
+// comment has to match expected as well.
+func (a Asset) verifyFileSignature(ctx context.Context, fileName string, sigPath string, expected SignedFile) error {
+	file, err := os.Open(fileName)
 	if err != nil {
-		return
+		return err
 	}
-	pSig, err := a.getSigFromCdn(sigPath)
+	defer file.Close()
+	_, err = a.verifySignedFile(ctx, file, sigPath, expected)
+	return err
+}
+
+// verifySignedFile verifies the signature at sigPath of the content read from r with the key of the KeyRing matching
+// its key id and returns the fields of its trusted comment. Errors of rejected signatures wrap ErrNoPublicKey,
+// ErrSignatureMissing, ErrUnknownKey, ErrRevokedKey, ErrSignatureInvalid or, with RequireSignedMetadata,
+// ErrMetadataMismatch.
+func (a Asset) verifySignedFile(ctx context.Context, r io.Reader, sigPath string, expected SignedFile) (signed SignedFile, err error) {
+	keyRing, err := a.getKeyRing()
 	if err != nil {
-		return
+		return SignedFile{}, err
+	}
+	pSig, err := a.getSigFromCdn(ctx, sigPath)
+	if err != nil {
+		return SignedFile{}, err
+	}
+	if a.LearnRotatedKeys {
+		if err = keyRing.learnKey(ctx, a.Client, pSig.KeyId, 0); err != nil {
+			return SignedFile{}, err
+		}
+	}
+	if err = keyRing.verify(r, *pSig); err != nil {
+		return SignedFile{}, err
+	}
+	if !a.RequireSignedMetadata {
+		return ParseTrustedComment(pSig.TrustedComment)
 	}
-	return pub.Verify(file, *pSig)
+	return expected.matches(pSig.TrustedComment)
 }
 
-func (a Asset) getSigFromCdn(sigPath string) (pSig *minisign.Signature, err error) {
-	data, err := a.Client.readData(sigPath)
+// getKeyRing returns the KeyRing of the asset, or one trusting UpdateFilesPubKey.
+func (a Asset) getKeyRing() (keyRing *KeyRing, err error) {
+	if a.KeyRing != nil {
+		return a.KeyRing, nil
+	}
+	if UpdateFilesPubKey == "" {
+		return nil, ErrNoPublicKey
+	}
+	if keyRing, err = NewKeyRing(UpdateFilesPubKey); err != nil {
+		return nil, fmt.Errorf("%w: invalid UpdateFilesPubKey: %v", ErrNoPublicKey, err)
+	}
+	return keyRing, nil
+}
+
+// verifySignature checks a minisign signature of the content read from r. Only prehashed "ED" signatures, created by
+// the uploader and minisign -S -H, are verified while streaming in constant memory. Legacy "Ed" signatures need the
+// whole content in memory, e.g. the whole update. Errors are only returned if r can not be read or the algorithm is not
+// supported.
+func verifySignature(pub minisign.PublicKey, r io.Reader, sig minisign.Signature) (sigValid bool, err error) {
+	switch sig.SignatureAlgorithm {
+	case legacySignatureAlgorithm:
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return false, err
+		}
+		// go-minisign reports mismatches as errors
+		sigValid, _ = pub.Verify(data, sig)
+		return sigValid, nil
+	case prehashedSignatureAlgorithm:
+		hash, err := blake2b.New512(nil)
+		if err != nil {
+			return false, err
+		}
+		if _, err = io.Copy(hash, r); err != nil {
+			return false, err
+		}
+		// A prehashed signature is a legacy signature over the hash.
+		sig.SignatureAlgorithm = legacySignatureAlgorithm
+		sigValid, _ = pub.Verify(hash.Sum(nil), sig)
+		return sigValid, nil
+	}
+	return false, errors.New("unsupported signature algorithm")
+}
+
+func (a Asset) getSigFromCdn(ctx context.Context, sigPath string) (pSig *minisign.Signature, err error) {
+	data, err := a.Client.readData(ctx, sigPath)
+	if isNotFound(err) {
+		return nil, fmt.Errorf("%s: %w", sigPath, ErrSignatureMissing)
+	}
 	if err != nil {
 		return nil, err
 	}
 	sig, err := minisign.DecodeSignature(string(data))
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%s: %w: %v", sigPath, ErrSignatureInvalid, err)
 	}
 	return &sig, nil
 }
@@ -0,0 +1,200 @@
+package updater
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/Flaque/filet"
+	"github.com/haevg-rz/go-updater/internal/signing"
+	"github.com/jedisct1/go-minisign"
+	"github.com/stretchr/testify/assert"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func newTestSecretKey(t *testing.T) signing.SecretKey {
+	key, err := signing.GenerateSecretKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// signTestContent returns a minisign signature of content. Prehashed signatures are created by key.Sign like the
+// uploader does, legacy signatures sign content itself like minisign without -H.
+func signTestContent(t *testing.T, key signing.SecretKey, algorithm [2]byte, content string, trustedComment string) string {
+	if algorithm == prehashedSignatureAlgorithm {
+		signature, err := key.Sign(strings.NewReader(content), trustedComment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signature
+	}
+	signature := ed25519.Sign(key.PrivateKey, []byte(content))
+	globalSignature := ed25519.Sign(key.PrivateKey, append(append([]byte{}, signature...), trustedComment...))
+	return fmt.Sprintf("untrusted comment: test signature\n%s\ntrusted comment: %s\n%s\n",
+		base64.StdEncoding.EncodeToString(append(append(algorithm[:], key.KeyId[:]...), signature...)),
+		trustedComment,
+		base64.StdEncoding.EncodeToString(globalSignature))
+}
+
+func TestSecretKeySignVerifies(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	key := newTestSecretKey(t)
+	defer func(pubKey string) { UpdateFilesPubKey = pubKey }(UpdateFilesPubKey)
+	UpdateFilesPubKey = key.PublicKey()
+	content := "Hello Gophers"
+
+	//act
+	signature, err := key.Sign(strings.NewReader(content), "timestamp:1612345678\tfile:HelloWorld_1.0.1.txt\thashed")
+
+	//assert
+	assert.NoError(t, err)
+	asset := Asset{Client: FSClient{FS: fstest.MapFS{"HelloWorld_1.0.1.txt.minisig": {Data: []byte(signature)}}}}
+	file := filet.TmpFile(t, "", content)
+	err = asset.verifyFileSignature(context.Background(), file.Name(), "HelloWorld_1.0.1.txt.minisig", SignedFile{})
+	assert.NoError(t, err)
+	sig, _ := minisign.DecodeSignature(signature)
+	assert.Equal(t, "trusted comment: timestamp:1612345678\tfile:HelloWorld_1.0.1.txt\thashed", sig.TrustedComment)
+
+	tampered := filet.TmpFile(t, "", content+"!")
+	err = asset.verifyFileSignature(context.Background(), tampered.Name(), "HelloWorld_1.0.1.txt.minisig", SignedFile{})
+	assert.True(t, errors.Is(err, ErrSignatureInvalid), err)
+}
+
+func Test_verifySignature(t *testing.T) {
+	key := newTestSecretKey(t)
+	otherKey := newTestSecretKey(t)
+	content := "Hello World And Hello Gophers!"
+	tests := []struct {
+		name      string
+		signature string
+		content   string
+		wantValid bool
+	}{
+		{"legacy signature", signTestContent(t, key, legacySignatureAlgorithm, content, "file:HelloWorld.txt"), content, true},
+		{"prehashed signature", signTestContent(t, key, prehashedSignatureAlgorithm, content, "file:HelloWorld.txt"), content, true},
+		{"tampered content", signTestContent(t, key, prehashedSignatureAlgorithm, content, "file:HelloWorld.txt"), "Hello World", false},
+		{"tampered legacy content", signTestContent(t, key, legacySignatureAlgorithm, content, "file:HelloWorld.txt"), "Hello World", false},
+		{"other key", signTestContent(t, otherKey, prehashedSignatureAlgorithm, content, "file:HelloWorld.txt"), content, false},
+	}
+	pub, err := minisign.NewPublicKey(key.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sig, err := minisign.DecodeSignature(tt.signature)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotValid, err := verifySignature(pub, strings.NewReader(tt.content), sig)
+			assert.Equal(t, tt.wantValid, gotValid)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestAsset_UpdateVerificationFailed(t *testing.T) {
+	defer filet.CleanUp(t)
+	key := newTestSecretKey(t)
+	keyRing, _ := NewKeyRing(key.PublicKey())
+	defer func(pubKey string) { UpdateFilesPubKey = pubKey }(UpdateFilesPubKey)
+	UpdateFilesPubKey = ""
+	unsigned := signedTestCdn(t, key)
+	delete(unsigned, "MyApp/beta/1/MyApp_1.0.1.txt.minisig")
+	tampered := signedTestCdn(t, key)
+	tampered["MyApp/beta/1/MyApp_1.0.1.txt"] = &fstest.MapFile{Data: []byte("tampered")}
+	tests := []struct {
+		name      string
+		cdn       fstest.MapFS
+		keyRing   *KeyRing
+		wantErrIs error
+	}{
+		{"no public key", signedTestCdn(t, key), nil, ErrNoPublicKey},
+		{"missing signature", unsigned, keyRing, ErrSignatureMissing},
+		{"tampered update", tampered, keyRing, ErrSignatureInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			//arrange
+			var failed []*VerificationError
+			asset := Asset{AssetName: "MyApp", AssetVersion: "1.0.0", Channel: "beta", Specs: map[string]string{}, Client: FSClient{FS: tt.cdn},
+				TargetFolder: filet.TmpDir(t, ""), KeyRing: tt.keyRing, OnVerificationFailed: func(err *VerificationError) { failed = append(failed, err) }}
+
+			//act
+			updatedTo, updated, err := asset.Update()
+
+			//assert
+			assert.Nil(t, updatedTo)
+			assert.False(t, updated)
+			assert.True(t, errors.Is(err, tt.wantErrIs), "got %v", err)
+			var verificationError *VerificationError
+			if assert.True(t, errors.As(err, &verificationError), "got %v", err) {
+				assert.Equal(t, "1.0.1", verificationError.Version)
+				assert.Equal(t, "MyApp/beta/1/MyApp_1.0.1.txt", verificationError.Path)
+				assert.Equal(t, []*VerificationError{verificationError}, failed)
+			}
+			assert.NoFileExists(t, asset.getPathToImportedUpdateFile("MyApp/beta/1/MyApp_1.0.1.txt"))
+		})
+	}
+}
+
+// unreadableFS fails to open the files with suffix with err.
+type unreadableFS struct {
+	fs     fstest.MapFS
+	suffix string
+	err    error
+}
+
+func (u unreadableFS) Open(name string) (fs.File, error) {
+	if strings.HasSuffix(name, u.suffix) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: u.err}
+	}
+	return u.fs.Open(name)
+}
+
+func TestAsset_UpdateVerificationNotPossible(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	key := newTestSecretKey(t)
+	keyRing, _ := NewKeyRing(key.PublicKey())
+	errUnreadable := errors.New("connection reset")
+	cdn := unreadableFS{fs: signedTestCdn(t, key), suffix: "MyApp_1.0.1.txt.minisig", err: errUnreadable}
+	var failed []*VerificationError
+	asset := Asset{AssetName: "MyApp", AssetVersion: "1.0.0", Channel: "beta", Specs: map[string]string{}, Client: FSClient{FS: cdn},
+		TargetFolder: filet.TmpDir(t, ""), KeyRing: keyRing, OnVerificationFailed: func(err *VerificationError) { failed = append(failed, err) }}
+
+	//act
+	updatedTo, updated, err := asset.Update()
+
+	//assert
+	assert.Nil(t, updatedTo)
+	assert.False(t, updated)
+	assert.True(t, errors.Is(err, errUnreadable), "got %v", err)
+	var verificationError *VerificationError
+	assert.False(t, errors.As(err, &verificationError), "got %v", err)
+	assert.Empty(t, failed)
+	assert.NoFileExists(t, asset.getPathToImportedUpdateFile("MyApp/beta/1/MyApp_1.0.1.txt"))
+}
+
+func TestAsset_UpdateWithoutPublicKeyDownloadsNothing(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	defer func(pubKey string) { UpdateFilesPubKey = pubKey }(UpdateFilesPubKey)
+	UpdateFilesPubKey = ""
+	cdn := unreadableFS{fs: signedTestCdn(t, newTestSecretKey(t)), suffix: "MyApp_1.0.1.txt", err: errors.New("update downloaded")}
+	asset := Asset{AssetName: "MyApp", AssetVersion: "1.0.0", Channel: "beta", Specs: map[string]string{}, Client: FSClient{FS: cdn}, TargetFolder: filet.TmpDir(t, "")}
+
+	//act
+	_, updated, err := asset.Update()
+
+	//assert
+	assert.False(t, updated)
+	assert.True(t, errors.Is(err, ErrNoPublicKey), "got %v", err)
+}
@@ -0,0 +1,166 @@
+package updater
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrRollback is returned if the updates source serves metadata older than metadata verified before.
+	ErrRollback = errors.New("metadata is older than metadata verified before")
+	// ErrFreeze is returned if the signed expiry of metadata has passed.
+	ErrFreeze = errors.New("metadata has expired")
+	// ErrRollbackProtectionUnsigned is returned by CheckForUpdates and Update if RollbackProtection is set without
+	// RequireSignedMetadata, whose signatures it compares.
+	ErrRollbackProtectionUnsigned = errors.New("RollbackProtection requires RequireSignedMetadata")
+)
+
+// ReplayError
+// Returned if the updates source replays old metadata, which hides newer updates (freeze) or offers older, possibly
+// vulnerable versions (rollback). Err is ErrRollback or ErrFreeze.
+type ReplayError struct {
+	File string
+	Err  error
+	// Signed are the fields of the rejected signature.
+	Signed SignedFile
+}
+
+func (e *ReplayError) Error() string {
+	return e.File + ": " + e.Err.Error()
+}
+
+func (e *ReplayError) Unwrap() error {
+	return e.Err
+}
+
+// assetState is persisted in the TargetFolder to detect replayed metadata.
+type assetState struct {
+	// Files holds the newest verified signature of every metadata file, keyed by its slash separated path.
+	Files map[string]fileState
+	// Repository holds the verified repository metadata in repository mode.
+	Repository *repositoryState `json:",omitempty"`
+}
+
+type fileState struct {
+	Timestamp int64
+	// HighestVersion is the highest version the file was signed for.
+	HighestVersion string `json:",omitempty"`
+	VerifiedAt     time.Time
+}
+
+// stateMutex serializes updates of state files by assets of the same process, e.g. Background and CheckForUpdates.
+var stateMutex sync.Mutex
+
+// checkReplay rejects expired metadata and, with RollbackProtection, metadata older than verified before. Newer
+// metadata is recorded in the state file.
+func (a Asset) checkReplay(signed SignedFile) error {
+	if signed.Expires != 0 && time.Now().Unix() > signed.Expires {
+		return &ReplayError{File: signed.File, Err: ErrFreeze, Signed: signed}
+	}
+	if !a.RollbackProtection {
+		return nil
+	}
+
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	state, err := a.readState()
+	if err != nil {
+		return err
+	}
+	seen, found := state.Files[signed.File]
+	if found && isOlderThan(signed, seen) {
+		return &ReplayError{File: signed.File, Err: ErrRollback, Signed: signed}
+	}
+	if !found || signed.Timestamp > seen.Timestamp {
+		seen.Timestamp = signed.Timestamp
+	}
+	if signed.Version != "" && (seen.HighestVersion == "" || isHigherVersion(signed.Version, seen.HighestVersion)) {
+		seen.HighestVersion = signed.Version
+	}
+	seen.VerifiedAt = time.Now().UTC()
+	state.Files[signed.File] = seen
+	return a.writeState(state)
+}
+
+// isOlderThan reports whether signed has an older timestamp or a lower version than seen.
+func isOlderThan(signed SignedFile, seen fileState) bool {
+	if signed.Timestamp < seen.Timestamp {
+		return true
+	}
+	return signed.Version != "" && seen.HighestVersion != "" && isHigherVersion(seen.HighestVersion, signed.Version)
+}
+
+func isHigherVersion(version string, other string) bool {
+	v, err := ParseVersion(version)
+	if err != nil {
+		return false
+	}
+	o, err := ParseVersion(other)
+	if err != nil {
+		return true
+	}
+	return o.LessThan(v)
+}
+
+func (a Asset) readState() (state assetState, err error) {
+	state.Files = map[string]fileState{}
+	data, err := ioutil.ReadFile(getPathToLocalStateJson(a.AssetName, a.TargetFolder))
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	if err = json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("invalid state file: %v", err)
+	}
+	if state.Files == nil {
+		state.Files = map[string]fileState{}
+	}
+	return state, nil
+}
+
+// writeState writes the state atomically, so an interrupted write never loses the state.
+func (a Asset) writeState(state assetState) error {
+	content, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(getPathToLocalStateJson(a.AssetName, a.TargetFolder), ".state-*", content)
+}
+
+// writeFileAtomic writes data to a temporary file named like pattern next to dest, syncs it and renames it to dest, so
+// readers never see a partial file and an interrupted write keeps the previous content.
+func writeFileAtomic(dest string, pattern string, data []byte) error {
+	tempFile, err := ioutil.TempFile(filepath.Dir(dest), pattern)
+	if err != nil {
+		return err
+	}
+	if _, err = tempFile.Write(data); err != nil {
+		_ = tempFile.Close()
+		_ = os.Remove(tempFile.Name())
+		return err
+	}
+	if err = tempFile.Sync(); err != nil {
+		_ = tempFile.Close()
+		_ = os.Remove(tempFile.Name())
+		return err
+	}
+	if err = tempFile.Close(); err != nil {
+		_ = os.Remove(tempFile.Name())
+		return err
+	}
+	return os.Rename(tempFile.Name(), dest)
+}
+
+// getPathToLocalStateJson example: installed\MyApp\MyApp_State.json
+func getPathToLocalStateJson(assetName string, targetFolder string) (stateJsonFilePath string) {
+	const stateJsonEnding = "_State.json"
+	return filepath.Join(targetFolder, assetName+stateJsonEnding)
+}
@@ -0,0 +1,97 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"github.com/Flaque/filet"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestAsset_CheckForUpdatesRejectsExpiredMetadata(t *testing.T) {
+	//arrange
+	key := newTestSecretKey(t)
+	keyRing, _ := NewKeyRing(key.PublicKey())
+	expired := SignedFile{Timestamp: time.Now().Add(-48 * time.Hour).Unix(), Expires: time.Now().Add(-time.Hour).Unix()}
+	asset := Asset{AssetName: "MyApp", AssetVersion: "1.0.0", Channel: "beta", Client: FSClient{FS: signedTestCdnAt(t, key, "1.0.1", expired)},
+		KeyRing: keyRing, RequireSignedMetadata: true}
+
+	//act
+	_, updateFound, err := asset.CheckForUpdatesContext(context.Background())
+
+	//assert
+	assert.False(t, updateFound)
+	var replayErr *ReplayError
+	if assert.True(t, errors.As(err, &replayErr), err) {
+		assert.Equal(t, ErrFreeze, replayErr.Err)
+		assert.Equal(t, "MyApp/beta/latest.txt", replayErr.File)
+	}
+}
+
+func TestAsset_CheckForUpdatesRejectsRollback(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	key := newTestSecretKey(t)
+	keyRing, _ := NewKeyRing(key.PublicKey())
+	targetFolder := filet.TmpDir(t, "")
+	newAsset := func(cdn fstest.MapFS) Asset {
+		return Asset{AssetName: "MyApp", AssetVersion: "1.0.0", Channel: "beta", Specs: map[string]string{}, Client: FSClient{FS: cdn},
+			KeyRing: keyRing, RequireSignedMetadata: true, RollbackProtection: true, TargetFolder: targetFolder}
+	}
+	current := signedTestCdnAt(t, key, "1.0.2", SignedFile{Timestamp: 2000})
+	replayed := signedTestCdnAt(t, key, "1.0.1", SignedFile{Timestamp: 1000})
+	// a stale major latest.txt served with the current channel latest.txt
+	mixed := signedTestCdnAt(t, key, "1.0.1", SignedFile{Timestamp: 2000})
+
+	//act
+	_, currentFound, currentErr := newAsset(current).CheckForUpdatesContext(context.Background())
+	_, replayedFound, replayedErr := newAsset(replayed).CheckForUpdatesContext(context.Background())
+	_, mixedFound, mixedErr := newAsset(mixed).CheckForUpdatesContext(context.Background())
+	_, currentAgainFound, currentAgainErr := newAsset(current).CheckForUpdatesContext(context.Background())
+
+	//assert
+	assert.NoError(t, currentErr)
+	assert.True(t, currentFound)
+	assert.True(t, errors.Is(replayedErr, ErrRollback), replayedErr)
+	assert.False(t, replayedFound)
+	assert.False(t, mixedFound)
+	var replayErr *ReplayError
+	if assert.True(t, errors.As(mixedErr, &replayErr), mixedErr) {
+		assert.Equal(t, ErrRollback, replayErr.Err)
+		assert.Equal(t, "MyApp/beta/1/latest.txt", replayErr.File)
+	}
+	assert.NoError(t, currentAgainErr)
+	assert.True(t, currentAgainFound)
+	state, err := newAsset(current).readState()
+	assert.NoError(t, err)
+	assert.Equal(t, "1.0.2", state.Files["MyApp/beta/1/latest.txt"].HighestVersion)
+	assert.Equal(t, int64(2000), state.Files["MyApp/beta/latest.txt"].Timestamp)
+}
+
+func TestAsset_checkReplayWithoutRollbackProtection(t *testing.T) {
+	asset := Asset{AssetName: "MyApp"}
+	assert.NoError(t, asset.checkReplay(SignedFile{File: "MyApp/beta/latest.txt", Timestamp: 1000}))
+	assert.NoError(t, asset.checkReplay(SignedFile{File: "MyApp/beta/latest.txt", Timestamp: 1}))
+}
+
+func TestAsset_UpdateRollbackProtectionWithoutSignedMetadata(t *testing.T) {
+	//arrange
+	defer filet.CleanUp(t)
+	key := newTestSecretKey(t)
+	keyRing, _ := NewKeyRing(key.PublicKey())
+	asset := Asset{AssetName: "MyApp", AssetVersion: "1.0.0", Channel: "beta", Specs: map[string]string{}, Client: FSClient{FS: signedTestCdn(t, key)},
+		KeyRing: keyRing, RollbackProtection: true, TargetFolder: filet.TmpDir(t, "")}
+
+	//act
+	_, updateFound, checkErr := asset.CheckForUpdates()
+	updatedTo, updated, updateErr := asset.Update()
+
+	//assert
+	assert.False(t, updateFound)
+	assert.Equal(t, ErrRollbackProtectionUnsigned, checkErr)
+	assert.Nil(t, updatedTo)
+	assert.False(t, updated)
+	assert.Equal(t, ErrRollbackProtectionUnsigned, updateErr)
+}
@@ -6,6 +6,7 @@
 package updater
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -22,18 +23,58 @@ type Asset struct {
 	DoMajorUpdate bool
 	Specs         map[string]string
 	TargetFolder  string
+
+	// AllowPrerelease decides if versions like "2.0.0-beta.3" are offered as updates. Defaults to PrereleaseNone.
+	AllowPrerelease PrereleasePolicy
+	// OnUpdateSkipped is called for every newer version CheckForUpdates does not offer, with the reason why.
+	OnUpdateSkipped func(skipped SkippedUpdate)
+	// OnProgress is called when an update enters a new Phase and repeatedly while downloading.
+	OnProgress func(progress Progress)
+	// KeyRing holds the public keys trusted to sign updates. Defaults to a KeyRing trusting UpdateFilesPubKey.
+	KeyRing *KeyRing
+	// RequireSignedMetadata verifies the signatures of latest.txt files and version jsons, like the signatures of updates.
+	// The trusted comments of all signatures have to bind them to the asset, channel, version and path of the file.
+	// Metadata signed with an expiry is rejected with a ReplayError once it has expired.
+	RequireSignedMetadata bool
+	// RollbackProtection persists the newest verified signature of every metadata file in {AssetName}_State.json in the
+	// TargetFolder and rejects older metadata with a ReplayError. Requires RequireSignedMetadata, CheckForUpdates fails
+	// with ErrRollbackProtectionUnsigned without it.
+	RollbackProtection bool
+	// Repository enables repository mode: every latest.txt, version json and update read from the updates source has to
+	// be listed with its length and hashes in targets metadata signed following the roles of The Update Framework, see
+	// Repository. Updates need no minisign signatures in this mode. The verified metadata versions are persisted in
+	// {AssetName}_State.json in the TargetFolder.
+	Repository *Repository
+	// OnVerificationFailed is called for every downloaded update rejected by its signature, the targets metadata or
+	// the size and hashes pinned in its version json, after the update has been removed again. Use it to record
+	// rejections in an audit log, I/O and network errors during verification are not reported.
+	OnVerificationFailed func(err *VerificationError)
+	// LearnRotatedKeys trusts keys published by "uploader rotate" in the updates source, if they are signed by a
+	// trusted key. Learned keys are added to KeyRing.
+	LearnRotatedKeys bool
 }
 
 type UpdateInfo struct {
 	Version string
 	Path    string
 	Type    string
+	// Size, Sha256 and Sha512 are pinned by the version json, they are empty if the uploader did not publish them.
+	Size   int64
+	Sha256 string
+	Sha512 string
 }
 
 // SelfUpdate
 // Looks for the latest available updates. Applies the newest update, terminating the running process and exchanging the executable files. Then restarts the application.
+// On Windows this is done by a batch script, on Linux/Unix the executable is replaced atomically and re-executed with the same arguments and environment.
 func (a Asset) SelfUpdate() (updatedTo *UpdateInfo, updated bool, err error) {
-	availableUpdates, updateFound, err := a.CheckForUpdates()
+	return a.SelfUpdateContext(context.Background())
+}
+
+// SelfUpdateContext
+// Like SelfUpdate, checking and downloading are canceled when ctx is done.
+func (a Asset) SelfUpdateContext(ctx context.Context) (updatedTo *UpdateInfo, updated bool, err error) {
+	availableUpdates, updateFound, err := a.CheckForUpdatesContext(ctx)
 	if err != nil {
 		return nil, false, err
 	}
@@ -46,18 +87,12 @@ func (a Asset) SelfUpdate() (updatedTo *UpdateInfo, updated bool, err error) {
 		return nil, false, err
 	}
 
-	localUpdateFile := a.getPathToImportedUpdateFile(latestUpdate.Path)
-	cdnSigFile := a.getCdnSigPath(latestUpdate.Path)
-
-	if err = a.saveRemoteFile(latestUpdate.Path, localUpdateFile); err != nil {
-		return nil, false, err
-	}
-
-	sigValid, err := a.isSignatureValid(localUpdateFile, cdnSigFile)
-	if !sigValid || (err != nil) {
+	localUpdateFile, err := a.downloadUpdate(ctx, latestUpdate)
+	if err != nil {
 		return nil, false, err
 	}
 
+	a.reportPhase(PhaseApplying)
 	if err = a.applySelfUpdate(localUpdateFile); err != nil {
 		return nil, false, err
 	}
@@ -68,7 +103,13 @@ func (a Asset) SelfUpdate() (updatedTo *UpdateInfo, updated bool, err error) {
 // Update
 // Looks for the latest available updates of an external Asset. Applies the newest updater and writes a versionJson into the asset folder, which points to the new version.
 func (a Asset) Update() (updatedTo *UpdateInfo, updated bool, err error) {
-	availableUpdates, updateFound, err := a.CheckForUpdates()
+	return a.UpdateContext(context.Background())
+}
+
+// UpdateContext
+// Like Update, checking and downloading are canceled when ctx is done.
+func (a Asset) UpdateContext(ctx context.Context) (updatedTo *UpdateInfo, updated bool, err error) {
+	availableUpdates, updateFound, err := a.CheckForUpdatesContext(ctx)
 	if err != nil {
 		return nil, false, err
 	}
@@ -82,18 +123,12 @@ func (a Asset) Update() (updatedTo *UpdateInfo, updated bool, err error) {
 		return nil, false, err
 	}
 
-	localUpdateFile := a.getPathToImportedUpdateFile(latestUpdate.Path)
-	cdnSigFile := a.getCdnSigPath(latestUpdate.Path)
-
-	if err = a.saveRemoteFile(latestUpdate.Path, localUpdateFile); err != nil {
-		return nil, false, err
-	}
-
-	sigValid, err := a.isSignatureValid(localUpdateFile, cdnSigFile)
-	if !sigValid || (err != nil) {
+	localUpdateFile, err := a.downloadUpdate(ctx, latestUpdate)
+	if err != nil {
 		return nil, false, err
 	}
 
+	a.reportPhase(PhaseApplying)
 	if err = a.applyUpdate(localUpdateFile); err != nil {
 		return nil, false, err
 	}
@@ -104,6 +139,38 @@ func (a Asset) Update() (updatedTo *UpdateInfo, updated bool, err error) {
 	return latestUpdate, true, nil
 }
 
+// downloadUpdate downloads the update next to the TargetFolder and verifies it. An update failing verification is
+// removed again and a *VerificationError is returned, so a caller never gets (nil, false, nil) for a rejected update.
+// I/O, network and context errors are returned unchanged, an update which could not be verified is removed as well.
+func (a Asset) downloadUpdate(ctx context.Context, update *UpdateInfo) (localUpdateFile string, err error) {
+	localUpdateFile = a.getPathToImportedUpdateFile(update.Path)
+	// without a key to verify its signature, nothing is downloaded
+	if a.Repository == nil {
+		if _, err = a.getKeyRing(); err != nil {
+			return "", a.verificationFailed(update, localUpdateFile, err)
+		}
+	}
+
+	err = a.saveRemoteFile(ctx, update.Path, localUpdateFile, update.getPinnedFile())
+	if isVerificationFailure(err) {
+		return "", a.verificationFailed(update, localUpdateFile, err)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	a.reportPhase(PhaseVerifying)
+	err = a.verifyUpdateFile(ctx, localUpdateFile, update)
+	if isVerificationFailure(err) {
+		return "", a.verificationFailed(update, localUpdateFile, err)
+	}
+	if err != nil {
+		removeUnverifiedUpdate(localUpdateFile)
+		return "", err
+	}
+	return localUpdateFile, nil
+}
+
 func (a Asset) getLatestAllowedUpdate(availableUpdates []UpdateInfo) (updateInfo *UpdateInfo, err error) {
 	if a.DoMajorUpdate {
 		for _, update := range availableUpdates {
@@ -148,10 +215,19 @@ func GetVersion(targetFolder string, assetName string) (currentVersion string) {
 //Background
 //Starts looking for updates in a specified interval. Use the allowUpdate function to enable/disable updates.
 func (a Asset) Background(interval time.Duration, skipUpdate func() bool, executeUpdateCallback func() (bool, error), executeAfterUpdateCallback func() error) (err error) {
+	return a.BackgroundContext(context.Background(), interval, skipUpdate, executeUpdateCallback, executeAfterUpdateCallback)
+}
+
+//BackgroundContext
+//Like Background, looking for updates stops when ctx is done. A running check or update is canceled.
+func (a Asset) BackgroundContext(ctx context.Context, interval time.Duration, skipUpdate func() bool, executeUpdateCallback func() (bool, error), executeAfterUpdateCallback func() error) (err error) {
 	ticker := time.NewTicker(interval)
 	go func() {
+		defer ticker.Stop()
 		for {
 			select {
+			case <-ctx.Done():
+				return
 			case t := <-ticker.C:
 				if skipUpdate() {
 					log.Println("update skipped at,", t)
@@ -159,7 +235,7 @@ func (a Asset) Background(interval time.Duration, skipUpdate func() bool, execut
 				}
 				fmt.Println("looking for updates at", t)
 				a.AssetVersion = GetVersion(a.TargetFolder, a.AssetName)
-				newUpdates, updateFound, err := a.CheckForUpdates()
+				newUpdates, updateFound, err := a.CheckForUpdatesContext(ctx)
 				if err != nil {
 					fmt.Println(err)
 					break
@@ -177,7 +253,7 @@ func (a Asset) Background(interval time.Duration, skipUpdate func() bool, execut
 					fmt.Println("Update not executed: executeUpdateCallback returned 'false'")
 					break
 				}
-				newVersion, _, err := a.Update()
+				newVersion, _, err := a.UpdateContext(ctx)
 				if err != nil {
 					fmt.Println(err)
 					break